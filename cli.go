@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// cliCommand is a subcommand of the binary
+type cliCommand struct {
+	name  string
+	usage string
+	help  string
+	run   func(args []string) error
+}
+
+// errUsage is returned for an invalid command line once the usage text has been printed;
+// main exits with status 2 for it
+var errUsage = errors.New("invalid usage")
+
+// cliCommands lists the subcommands in the order they are shown in the usage text
+var cliCommands []cliCommand
+
+func init() {
+	cliCommands = []cliCommand{
+		{"run", "", "poll every feed until stopped (the default)", runDaemon},
+		{"once", "[feed...]", "poll every feed, or the given feeds, once and exit", runOnce},
+		{"dry-run", "[-html] [feed...]", "print the messages new items would produce without sending or recording anything", runDryRun},
+		{"test-match", "[-profile NAME] \"<torrent title>\"", "show the name extracted from a title and the score breakdown of each IGDB candidate", runTestMatch},
+		{"backfill", "[-since DURATION|DATE] [feed...]", "mark the items currently in the feeds as seen without posting them", runBackfill},
+		{"invalidate-cache", "[-all | -media | -game ID | <query>...]", "drop IGDB lookups or uploaded media from the cache", runInvalidateCache},
+		{"help", "", "show this help", func([]string) error { printUsage(os.Stdout); return nil }},
+	}
+}
+
+// runCommand runs the subcommand named by the first argument, or the daemon when there is none
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		return runDaemon(nil)
+	}
+	for _, cmd := range cliCommands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		printUsage(os.Stdout)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return errUsage
+}
+
+// printUsage lists the subcommands
+func printUsage(w *os.File) {
+	fmt.Fprintf(w, "Usage: %s <command> [arguments]\n\nCommands:\n", os.Args[0])
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range cliCommands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.usage, cmd.help)
+	}
+	tw.Flush()
+}
+
+// newFlagSet creates the flag set of a subcommand with a usage line taken from the command table
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, cmd := range cliCommands {
+			if cmd.name == name {
+				fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n%s\n", os.Args[0], cmd.name, cmd.usage, cmd.help)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses the arguments of a subcommand. The flag package prints parse errors
+// with the usage text, so they are returned as errUsage; -h returns flag.ErrHelp.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errUsage
+	}
+	return err
+}
+
+// signalContext returns a context that is cancelled by SIGINT or SIGTERM. Work in progress
+// finishes or stays in the outbox; a second signal kills the process.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Println("Shutting down, send the signal again to exit immediately...")
+	}()
+	return ctx, stop
+}
+
+// processorMode selects the clients openProcessor sets up
+type processorMode int
+
+const (
+	processorPosting processorMode = iota // Matrix and IGDB, for commands that post
+	processorLookup                       // IGDB only
+	processorDryRun                       // IGDB only, leaving the IGDB cache and saved token untouched
+)
+
+// openProcessor loads the configuration, opens the database and creates a processor for mode
+func openProcessor(mode processorMode) (*RSSProcessor, *sql.DB, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %v", err)
+	}
+	setLogLevel(config.LogLevel)
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize DB: %v", err)
+	}
+	log.Println("SQLite DB initialized.")
+
+	var processor *RSSProcessor
+	if mode == processorPosting {
+		processor, err = NewRSSProcessor(config, db)
+	} else {
+		processor, err = newLookupProcessor(config, db, mode == processorDryRun)
+	}
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("failed to create RSS processor: %v", err)
+	}
+	return processor, db, nil
+}
+
+// selectFeeds returns the configured feeds with the given names, or all of them when no names are given
+func selectFeeds(cfg *Config, names []string) ([]*FeedConfig, error) {
+	if len(names) == 0 {
+		return cfg.Feeds, nil
+	}
+	var feeds []*FeedConfig
+	for _, name := range names {
+		var found *FeedConfig
+		for _, feed := range cfg.Feeds {
+			if strings.EqualFold(feed.Name, name) {
+				found = feed
+				break
+			}
+		}
+		if found == nil {
+			return nil, fmt.Errorf("unknown feed %q", name)
+		}
+		feeds = append(feeds, found)
+	}
+	return feeds, nil
+}
+
+// runOnce implements the once command: a single poll of the feeds, e.g. from cron
+func runOnce(args []string) error {
+	fs := newFlagSet("once")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	processor, db, err := openProcessor(processorPosting)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	defer processor.matrixClient.Close()
+	config := processor.currentConfig()
+	feeds, err := selectFeeds(config, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	// Encryption needs a running sync to share room keys
+	syncCtx, stopSync := context.WithCancel(ctx)
+	syncDone := make(chan struct{})
+	if processor.matrixClient.Encrypted() {
+		go func() {
+			defer close(syncDone)
+			processor.matrixClient.Sync(syncCtx, []string{config.MatrixRoomID})
+		}()
+	} else {
+		close(syncDone)
+	}
+	defer func() {
+		stopSync()
+		<-syncDone
+	}()
+
+	failed := 0
+	for _, feed := range feeds {
+		if err := processor.processFeed(ctx, db, feed); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("[%s] Error processing feed: %v", feed.Name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d feeds failed", failed, len(feeds))
+	}
+	return nil
+}
+
+// runDryRun implements the dry-run command: print the messages that new feed items would
+// produce. Nothing is sent or written to the database, not even IGDB lookups or tokens, so a
+// later poll still posts the items.
+func runDryRun(args []string) error {
+	fs := newFlagSet("dry-run")
+	showHTML := fs.Bool("html", false, "print the HTML bodies instead of the plain text")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	processor, db, err := openProcessor(processorDryRun)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	feeds, err := selectFeeds(processor.currentConfig(), fs.Args())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	for _, feed := range feeds {
+		items, err := processor.fetchFeedItems(ctx, feed)
+		if err != nil {
+			log.Printf("[%s] %v", feed.Name, err)
+			continue
+		}
+		for _, item := range items {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			processor.dryRunItem(ctx, db, feed, item, *showHTML)
+		}
+	}
+	return nil
+}
+
+// dryRunItem prints the messages an item would be posted as, or why it would not be posted
+func (rp *RSSProcessor) dryRunItem(ctx context.Context, db *sql.DB, feed *FeedConfig, item *TorznabItem, showHTML bool) {
+	if processed, err := isPostProcessed(db, item.GUID); err != nil {
+		log.Printf("DB error: %v", err)
+		return
+	} else if processed {
+		return
+	}
+
+	parsed := rp.extractGameName(item.Title, feed.Profile)
+	release := NewReleaseFromItem(feed, item)
+	release.SetParsedTitle(parsed)
+	if muted, err := isGameMuted(db, parsed.Name); err == nil && muted {
+		fmt.Printf("=== [%s] %s: skipped, %s is muted ===\n\n", feed.Name, item.Title, parsed.Name)
+		return
+	}
+
+	igdbInfo, err := rp.igdbClient.SearchGameWithImages(ctx, parsed.Name)
+	if err != nil {
+		log.Printf("Failed to get IGDB info for %s: %v", parsed.Name, err)
+		igdbInfo = nil
+	} else if muted, err := isGameMuted(db, igdbInfo.Title); err == nil && muted {
+		fmt.Printf("=== [%s] %s: skipped, %s is muted ===\n\n", feed.Name, item.Title, igdbInfo.Title)
+		return
+	}
+
+	rec := &ReleaseRecord{GUID: item.GUID, Feed: feed.Name, RoomID: feed.RoomID, CreatedAt: time.Now()}
+	earlier := rp.findEarlierNotification(db, rec, igdbInfo)
+	routes := rp.routesFor(feed.Name, feed.RoomID, release, igdbInfo)
+	if len(routes) == 0 {
+		fmt.Printf("=== [%s] %s: no room ===\n\n", feed.Name, item.Title)
+		return
+	}
+	for _, route := range routes {
+		kind := templateRelease
+		data := &MessageData{Name: parsed.Name, Release: release, Verbosity: route.verbosity()}
+		if igdbInfo != nil {
+			kind = templateGame
+			data.Name = igdbInfo.Title
+			data.Game = igdbInfo
+			if earlier.rootIn(route.RoomID) != "" {
+				kind = templateUpdate
+			}
+		}
+		text, html, err := route.messageTemplates().Render(kind, data)
+		if err != nil {
+			log.Printf("Failed to render %s message for %s: %v", kind, route.RoomID, err)
+			continue
+		}
+		body := text
+		if showHTML {
+			body = html
+		}
+		fmt.Printf("=== [%s] %s -> %s (%s, %s) ===\n%s\n\n", feed.Name, item.Title, route.RoomID, route.Name, kind, body)
+	}
+}
+
+// runTestMatch implements the test-match command: show how a torrent title is matched on IGDB
+func runTestMatch(args []string) error {
+	fs := newFlagSet("test-match")
+	profile := fs.String("profile", "default", "title profile used to extract the game name")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	if _, ok := titleProfiles[*profile]; !ok {
+		return fmt.Errorf("unknown title profile %q", *profile)
+	}
+	title := strings.Join(fs.Args(), " ")
+
+	processor, db, err := openProcessor(processorLookup)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	parsed := processor.extractGameName(title, *profile)
+	fmt.Printf("Title:     %s\n", parsed.Raw)
+	fmt.Printf("Name:      %s\n", parsed.Name)
+	fmt.Printf("Version:   %s\n", parsed.Version)
+	fmt.Printf("Build:     %s\n", parsed.Build)
+	fmt.Printf("Group:     %s\n", parsed.Group)
+	fmt.Printf("Repack:    %t\n", parsed.Repack)
+	fmt.Printf("Edition:   %s\n", parsed.Edition)
+	fmt.Printf("DLCs:      %s\n", strings.Join(parsed.DLCs, ", "))
+	fmt.Printf("Languages: %s\n", strings.Join(parsed.Languages, ", "))
+
+	if info, found, err := processor.igdbClient.cache.Lookup(parsed.Name); err != nil {
+		log.Printf("IGDB cache lookup failed for '%s': %v", parsed.Name, err)
+	} else if found && info == nil {
+		fmt.Printf("Cached:    no match\n")
+	} else if found {
+		fmt.Printf("Cached:    %s (IGDB %d, score %.3f)\n", info.Title, info.ID, info.MatchScore)
+	}
+
+	candidates, err := processor.igdbClient.MatchCandidates(ctx, parsed.Name)
+	if errors.Is(err, ErrNoIGDBMatch) {
+		fmt.Printf("\nNo IGDB candidates.\n")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nIGDB candidates (the first is selected):\n")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "SCORE\tBASE\tRECENCY\tCATEGORY\tAGE\tPENALTY WORD\tID\tRELEASED\tNAME\n")
+	for _, c := range candidates {
+		b := c.Breakdown
+		word := "-"
+		if b.PenaltyWord != "" {
+			word = fmt.Sprintf("%q x%.2f", b.PenaltyWord, b.WordPenalty)
+		}
+		fmt.Fprintf(tw, "%.3f\t%.3f\t%.3f\t%.3f\tx%.2f\t%s\t%d\t%s\t%s\n", b.Total, b.Base, b.Recency, b.Category,
+			b.AgePenalty, word, c.Game.ID, formatReleaseDate(int64(c.Game.FirstReleaseDate)), c.Game.Name)
+	}
+	return tw.Flush()
+}
+
+// backfillPageSize and backfillMaxPages bound how far backfill -since pages back through a feed
+const (
+	backfillPageSize = 100
+	backfillMaxPages = 50
+)
+
+// runBackfill implements the backfill command: record the items currently in the feeds as
+// skipped, so that onboarding a feed doesn't flood its room with old releases
+func runBackfill(args []string) error {
+	fs := newFlagSet("backfill")
+	sinceFlag := fs.String("since", "", "also page back through older items until this age (e.g. 72h) or date (2006-01-02)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = parseSince(*sinceFlag, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	processor, db, err := openProcessor(processorLookup)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	feeds, err := selectFeeds(processor.currentConfig(), fs.Args())
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signalContext()
+	defer stop()
+
+	for _, feed := range feeds {
+		marked, err := processor.backfillFeed(ctx, db, feed, since)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("[%s] %v", feed.Name, err)
+		}
+		log.Printf("[%s] Marked %d items as seen", feed.Name, marked)
+	}
+	return nil
+}
+
+// backfillFeed marks a feed's unprocessed items as skipped. With a zero since only the items a
+// poll would see are marked; otherwise older pages are fetched until items before since appear.
+func (rp *RSSProcessor) backfillFeed(ctx context.Context, db *sql.DB, feed *FeedConfig, since time.Time) (int, error) {
+	if since.IsZero() {
+		items, err := rp.fetchFeedItems(ctx, feed)
+		if err != nil {
+			return 0, err
+		}
+		return rp.backfillItems(db, feed, items), nil
+	}
+
+	tc := NewTorznabClient(feed.URL, feed.APIKey, rp.client)
+	marked := 0
+	for page := 0; page < backfillMaxPages; page++ {
+		pageCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+		items, err := tc.Search(pageCtx, TorznabQuery{Categories: feed.Categories, Limit: backfillPageSize, Offset: page * backfillPageSize})
+		cancel()
+		if err != nil {
+			return marked, fmt.Errorf("failed to query Torznab feed: %v", err)
+		}
+		marked += rp.backfillItems(db, feed, items)
+		if len(items) < backfillPageSize {
+			return marked, nil
+		}
+		for _, item := range items {
+			if !item.PubDate.IsZero() && item.PubDate.Before(since) {
+				return marked, nil
+			}
+		}
+	}
+	log.Printf("[%s] Stopped backfilling after %d pages", feed.Name, backfillMaxPages)
+	return marked, nil
+}
+
+// backfillItems records the items that were not processed yet as skipped and returns how many there were
+func (rp *RSSProcessor) backfillItems(db *sql.DB, feed *FeedConfig, items []*TorznabItem) int {
+	marked := 0
+	for _, item := range items {
+		processed, err := isPostProcessed(db, item.GUID)
+		if err != nil {
+			log.Printf("DB error: %v", err)
+			continue
+		}
+		if processed {
+			continue
+		}
+		parsed := rp.extractGameName(item.Title, feed.Profile)
+		release := NewReleaseFromItem(feed, item)
+		release.SetParsedTitle(parsed)
+		rec, err := rp.queueRelease(db, feed, parsed.Name, release)
+		if err != nil {
+			log.Printf("Failed to queue release %s: %v", item.GUID, err)
+			continue
+		}
+		rp.skipRelease(db, rec, "backfill")
+		marked++
+	}
+	return marked
+}
+
+// parseSince parses a -since value: a duration before now, or a date
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid -since %q: want a duration such as 72h or a date such as 2006-01-02", value)
+}
+
+// runInvalidateCache implements the invalidate-cache command
+func runInvalidateCache(args []string) error {
+	fs := newFlagSet("invalidate-cache")
+	gameID := fs.Int("game", 0, "invalidate an IGDB game ID and every query that resolved to it")
+	all := fs.Bool("all", false, "invalidate the whole IGDB cache")
+	media := fs.Bool("media", false, "forget uploaded images, e.g. after media was purged from the homeserver")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	db, err := initDB(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to initialize DB: %v", err)
+	}
+	defer db.Close()
+	cache := NewIGDBCache(db, 0, 0)
+
+	if *media {
+		removed, err := NewMediaCache(db).InvalidateAll()
+		if err != nil {
+			return fmt.Errorf("failed to invalidate cache: %v", err)
+		}
+		log.Printf("Removed %d media cache entries", removed)
+		return nil
+	}
+
+	var removed int64
+	switch {
+	case *all:
+		removed, err = cache.InvalidateAll()
+	case *gameID != 0:
+		removed, err = cache.InvalidateGame(*gameID)
+	case fs.NArg() > 0:
+		removed, err = cache.InvalidateQuery(strings.Join(fs.Args(), " "))
+	default:
+		fs.Usage()
+		return errUsage
+	}
+	if err != nil {
+		return fmt.Errorf("failed to invalidate cache: %v", err)
+	}
+	log.Printf("Removed %d IGDB cache entries", removed)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommandUsageErrors(t *testing.T) {
+	// invalidate-cache opens the database before checking its arguments, so run in a
+	// directory of our own
+	useTestConfig(t, testConfig)
+
+	for _, args := range [][]string{
+		{"nosuchcommand"},
+		{"once", "-nosuchflag"},
+		{"dry-run", "-html=maybe"},
+		{"test-match"},
+		{"backfill", "-since"},
+		{"invalidate-cache"},
+	} {
+		if err := runCommand(args); !errors.Is(err, errUsage) {
+			t.Errorf("%s: got %v, want errUsage", strings.Join(args, " "), err)
+		}
+	}
+	if err := runCommand([]string{"backfill", "-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("backfill -h: got %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	if got, err := parseSince("72h", now); err != nil || !got.Equal(now.Add(-72*time.Hour)) {
+		t.Errorf("72h: got %v, %v", got, err)
+	}
+	if got, err := parseSince("2024-05-01", now); err != nil || !got.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("2024-05-01: got %v, %v", got, err)
+	}
+	if _, err := parseSince("last week", now); err == nil {
+		t.Error("expected an error for an unparseable -since")
+	}
+}
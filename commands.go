@@ -0,0 +1,495 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	mautrixID "maunium.net/go/mautrix/id"
+)
+
+// BotCommands syncs the notification room and answers commands sent to the bot
+type BotCommands struct {
+	ctx       context.Context // cancels IGDB lookups and sends of running commands on shutdown
+	rp        *RSSProcessor
+	db        *sql.DB
+	client    *mautrix.Client
+	roomID    mautrixID.RoomID
+	allowed   map[mautrixID.UserID]bool
+	minLevel  int
+	startedAt time.Time
+	queue     chan *event.Event // commands waiting for Serve, so the sync loop never waits on them
+}
+
+// commandQueueSize is how many commands may wait to run; more are dropped
+const commandQueueSize = 16
+
+// botCommand describes a command; run returns the plain text reply and an optional HTML version
+type botCommand struct {
+	name    string
+	usage   string
+	help    string
+	minArgs int
+	maxArgs int // -1 for no limit
+	run     func(bc *BotCommands, evt *event.Event, args []string) (string, string, error)
+}
+
+// botCommands lists the supported commands in the order !help shows them
+var botCommands = []botCommand{
+	{name: "help", usage: "!help", help: "Show this help"},
+	{name: "status", usage: "!status", help: "Show feeds and delivery counts", run: (*BotCommands).cmdStatus},
+	{name: "last", usage: "!last [count]", help: "List the most recent releases (default 10)", maxArgs: 1, run: (*BotCommands).cmdLast},
+	{name: "search", usage: "!search <name>", help: "Look a game up on IGDB", minArgs: 1, maxArgs: -1, run: (*BotCommands).cmdSearch},
+	{name: "rematch", usage: "!rematch <event> <igdb-id>", help: "Resend a notification with the given IGDB game and use it for that name from now on", minArgs: 2, maxArgs: 2, run: (*BotCommands).cmdRematch},
+	{name: "mute", usage: "!mute [game]", help: "Stop notifications for a game, or list muted games", maxArgs: -1, run: (*BotCommands).cmdMute},
+	{name: "unmute", usage: "!unmute <game>", help: "Resume notifications for a game", minArgs: 1, maxArgs: -1, run: (*BotCommands).cmdUnmute},
+}
+
+// NewBotCommands creates the command handler for the processor's notification room
+func NewBotCommands(ctx context.Context, rp *RSSProcessor, db *sql.DB) *BotCommands {
+	cfg := rp.currentConfig()
+	allowed := map[mautrixID.UserID]bool{}
+	for _, user := range cfg.MatrixCommandUsers {
+		allowed[mautrixID.UserID(user)] = true
+	}
+	return &BotCommands{
+		ctx:       ctx,
+		rp:        rp,
+		db:        db,
+		client:    rp.matrixClient.client,
+		roomID:    mautrixID.RoomID(cfg.MatrixRoomID),
+		allowed:   allowed,
+		minLevel:  cfg.MatrixCommandLevel,
+		startedAt: time.Now(),
+		queue:     make(chan *event.Event, commandQueueSize),
+	}
+}
+
+// Register adds the command handler to the client's syncer; commands received while the client
+// syncs are answered by Serve
+func (bc *BotCommands) Register() {
+	syncer, ok := bc.client.Syncer.(mautrix.ExtensibleSyncer)
+	if !ok {
+		log.Printf("Matrix commands disabled: unsupported syncer %T", bc.client.Syncer)
+		return
+	}
+	syncer.OnEventType(event.EventMessage, bc.handleEvent)
+	log.Printf("Listening for commands in %s", bc.roomID)
+}
+
+// handleEvent queues room messages that look like commands. It runs inside the sync loop, so
+// everything that talks to the homeserver or IGDB is left to Serve.
+func (bc *BotCommands) handleEvent(source mautrix.EventSource, evt *event.Event) {
+	if evt.RoomID != bc.roomID || evt.Sender == bc.client.UserID {
+		return
+	}
+	// The first sync returns recent history; never act on commands sent before we started
+	if time.UnixMilli(evt.Timestamp).Before(bc.startedAt) {
+		return
+	}
+	content := evt.Content.AsMessage()
+	if content == nil || content.MsgType != event.MsgText {
+		return
+	}
+	if rel := content.GetRelatesTo(); rel != nil && rel.Type == event.RelReplace {
+		return
+	}
+	content.RemoveReplyFallback()
+	if !strings.HasPrefix(strings.TrimSpace(content.Body), "!") {
+		return
+	}
+
+	select {
+	case bc.queue <- evt:
+	default:
+		log.Printf("Dropping command from %s: too many commands waiting", evt.Sender)
+	}
+}
+
+// Serve runs queued commands one at a time until the context is cancelled
+func (bc *BotCommands) Serve() {
+	for {
+		select {
+		case <-bc.ctx.Done():
+			return
+		case evt := <-bc.queue:
+			bc.runCommand(evt)
+		}
+	}
+}
+
+// runCommand checks that the sender may use commands, then parses the command and replies.
+// Unauthorized senders are ignored without a reply, so they cannot make the bot post.
+func (bc *BotCommands) runCommand(evt *event.Event) {
+	if !bc.authorized(evt.Sender) {
+		log.Printf("Ignoring command from unauthorized user %s", evt.Sender)
+		return
+	}
+
+	name, args, err := parseCommandLine(evt.Content.AsMessage().Body)
+	if err != nil {
+		bc.reply(evt, "⚠️ "+err.Error(), "")
+		return
+	}
+	cmd := findBotCommand(name)
+	if cmd == nil {
+		bc.reply(evt, fmt.Sprintf("Unknown command !%s, try !help", name), "")
+		return
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		bc.reply(evt, "Usage: "+cmd.usage, "")
+		return
+	}
+
+	log.Printf("Running !%s for %s", name, evt.Sender)
+	if cmd.run == nil {
+		bc.reply(evt, bc.helpText(), "")
+		return
+	}
+	text, htmlText, err := cmd.run(bc, evt, args)
+	if err != nil {
+		log.Printf("Command !%s failed: %v", name, err)
+		bc.reply(evt, "⚠️ "+err.Error(), "")
+		return
+	}
+	bc.reply(evt, text, htmlText)
+}
+
+// authorized reports whether a user may run commands: allow-listed users if a list is
+// configured, otherwise anyone with at least the configured power level in the room
+func (bc *BotCommands) authorized(userID mautrixID.UserID) bool {
+	if len(bc.allowed) > 0 {
+		return bc.allowed[userID]
+	}
+	var levels event.PowerLevelsEventContent
+	if err := bc.client.StateEvent(bc.roomID, event.StatePowerLevels, "", &levels); err != nil {
+		log.Printf("Failed to get power levels of %s: %v", bc.roomID, err)
+		return false
+	}
+	return levels.GetUserLevel(userID) >= bc.minLevel
+}
+
+// reply sends a notice in reply to a command
+func (bc *BotCommands) reply(evt *event.Event, text, htmlText string) {
+	content := &event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    text,
+	}
+	if htmlText != "" {
+		content.Format = event.FormatHTML
+		content.FormattedBody = htmlText
+	}
+	content.RelatesTo = (&event.RelatesTo{}).SetReplyTo(evt.ID)
+	if _, err := bc.client.SendMessageEvent(bc.roomID, event.EventMessage, content); err != nil {
+		log.Printf("Failed to reply to command: %v", err)
+	}
+}
+
+// helpText lists the available commands
+func (bc *BotCommands) helpText() string {
+	lines := []string{"Available commands:"}
+	for _, cmd := range botCommands {
+		lines = append(lines, fmt.Sprintf("%s — %s", cmd.usage, cmd.help))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// cmdStatus reports uptime, feeds and release counts
+func (bc *BotCommands) cmdStatus(evt *event.Event, args []string) (string, string, error) {
+	counts, err := releaseStatusCounts(bc.db)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to count releases: %v", err)
+	}
+	muted, err := mutedGames(bc.db)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list muted games: %v", err)
+	}
+
+	var feeds []string
+	for _, feed := range bc.rp.currentConfig().Feeds {
+		feeds = append(feeds, fmt.Sprintf("%s (every %s)", feed.Name, feed.Interval))
+	}
+	lines := []string{
+		"📊 Status",
+		"Uptime: " + time.Since(bc.startedAt).Round(time.Second).String(),
+		"Feeds: " + strings.Join(feeds, ", "),
+		fmt.Sprintf("Releases: %d sent, %d pending, %d skipped, %d dead",
+			counts[ReleaseStatusSent], counts[ReleaseStatusPending], counts[ReleaseStatusSkipped], counts[ReleaseStatusDead]),
+		fmt.Sprintf("Muted games: %d", len(muted)),
+	}
+	return strings.Join(lines, "\n"), "", nil
+}
+
+// cmdLast lists the most recent releases
+func (bc *BotCommands) cmdLast(evt *event.Event, args []string) (string, string, error) {
+	limit := 10
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > 50 {
+			return "", "", fmt.Errorf("count must be a number between 1 and 50")
+		}
+		limit = n
+	}
+
+	recs, err := recentReleases(bc.db, limit)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load releases: %v", err)
+	}
+	if len(recs) == 0 {
+		return "No releases yet", "", nil
+	}
+
+	lines := []string{fmt.Sprintf("Last %d releases:", len(recs))}
+	for _, rec := range recs {
+		name := rec.ExtractedName
+		if name == "" {
+			name = rec.RawTitle
+		}
+		if name == "" {
+			name = rec.GUID
+		}
+		line := fmt.Sprintf("%s %s %s", rec.CreatedAt.Format("2006-01-02 15:04"), releaseStatusIcon(rec.Status), name)
+		if rec.Feed != "" {
+			line += " [" + rec.Feed + "]"
+		}
+		if rec.IGDBID != 0 {
+			line += fmt.Sprintf(" → IGDB %d", rec.IGDBID)
+		}
+		if rec.Error != "" {
+			line += " (" + rec.Error + ")"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), "", nil
+}
+
+// cmdSearch looks a game up on IGDB and shows what a notification would contain
+func (bc *BotCommands) cmdSearch(evt *event.Event, args []string) (string, string, error) {
+	query := strings.Join(args, " ")
+	info, err := bc.rp.igdbClient.SearchGameWithImages(bc.ctx, query)
+	if errors.Is(err, ErrNoIGDBMatch) {
+		return fmt.Sprintf("No IGDB match for %q", query), "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	text, htmlText, err := renderGameMessage(info, nil, &RoomRoute{Verbosity: VerbosityFull, Templates: bc.rp.currentConfig().Templates})
+	if err != nil {
+		return "", "", err
+	}
+	footer := fmt.Sprintf("IGDB ID %d, match score %.2f", info.ID, info.MatchScore)
+	text += "\n🔗 " + info.IGDBURL + "\n" + footer
+	htmlText += fmt.Sprintf("\n<p>🔗 <a href=\"%s\">%s</a><br>%s</p>", html.EscapeString(info.IGDBURL), html.EscapeString(info.IGDBURL), footer)
+	return text, htmlText, nil
+}
+
+// cmdRematch resends a release's notification with the given IGDB game, redacts the old
+// notification and pins the match so later releases of the same name use it
+func (bc *BotCommands) cmdRematch(evt *event.Event, args []string) (string, string, error) {
+	eventID := parseEventRef(args[0])
+	gameID, err := strconv.Atoi(args[1])
+	if err != nil || gameID <= 0 {
+		return "", "", fmt.Errorf("invalid IGDB ID %q", args[1])
+	}
+
+	rec, err := releaseByEventID(bc.db, eventID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find release: %v", err)
+	}
+	if rec == nil {
+		return "", "", fmt.Errorf("no release notification with event %s", eventID)
+	}
+	var payload outboxPayload
+	if err := json.Unmarshal([]byte(rec.Payload), &payload); err != nil || payload.Release == nil {
+		return "", "", fmt.Errorf("release %q has no stored details to resend", rec.RawTitle)
+	}
+
+	info, err := bc.rp.igdbClient.GetGameByID(bc.ctx, gameID)
+	if err != nil {
+		return "", "", err
+	}
+	if err := bc.rp.igdbClient.PinMatch(rec.ExtractedName, info); err != nil {
+		log.Printf("Failed to pin IGDB match for '%s': %v", rec.ExtractedName, err)
+	}
+
+	// Send the corrected notification wherever the new game routes it, then redact the old ones.
+	// Rooms the new notification could not be sent to keep their old one.
+	resent := &outboxPayload{Release: payload.Release}
+	routes := bc.rp.routesFor(rec.Feed, rec.RoomID, payload.Release, info)
+	var failed []string
+	failedRooms := map[string]bool{}
+	for _, route := range routes {
+		eventIDs, err := bc.rp.sendToRoute(bc.ctx, route, rec.ExtractedName, payload.Release, info)
+		if err != nil {
+			log.Printf("Failed to send rematched notification to %s: %v", route.RoomID, err)
+			bc.rp.matrixClient.ForRoom(route.RoomID).RedactEvents(eventIDs, "Rematch failed")
+			failed = append(failed, fmt.Sprintf("%s (%v)", route.RoomID, err))
+			failedRooms[route.RoomID] = true
+			continue
+		}
+		resent.addDelivery(route.RoomID, eventIDs)
+	}
+	if len(routes) > 0 && len(failed) == len(routes) {
+		return "", "", fmt.Errorf("failed to send notification to %s", strings.Join(failed, ", "))
+	}
+	oldDeliveries := payload.Deliveries
+	if len(oldDeliveries) == 0 {
+		// Releases sent before routing only know their event IDs
+		oldDeliveries = map[string][]string{rec.RoomID: rec.EventIDs}
+	}
+	for roomID, eventIDs := range oldDeliveries {
+		if failedRooms[roomID] {
+			resent.addDelivery(roomID, eventIDs)
+			continue
+		}
+		bc.rp.matrixClient.ForRoom(roomID).RedactEvents(eventIDs, "Rematched to "+info.Title)
+	}
+
+	rec.EventIDs = nil
+	for _, eventIDs := range resent.Deliveries {
+		rec.EventIDs = append(rec.EventIDs, eventIDs...)
+	}
+	if data, err := json.Marshal(resent); err == nil {
+		rec.Payload = string(data)
+	}
+	rec.IGDBID = info.ID
+	rec.MatchScore = info.MatchScore
+	rec.Status = ReleaseStatusSent
+	rec.Error = ""
+	if err := saveRelease(bc.db, rec); err != nil {
+		log.Printf("Failed to record release %s: %v", rec.GUID, err)
+	}
+	reply := fmt.Sprintf("Rematched %q to %s (IGDB %d)", rec.ExtractedName, info.Title, info.ID)
+	if len(failed) > 0 {
+		reply += "; failed to send to " + strings.Join(failed, ", ")
+	}
+	return reply, "", nil
+}
+
+// cmdMute mutes a game, or lists muted games without arguments
+func (bc *BotCommands) cmdMute(evt *event.Event, args []string) (string, string, error) {
+	if len(args) == 0 {
+		names, err := mutedGames(bc.db)
+		if err != nil {
+			return "", "", err
+		}
+		if len(names) == 0 {
+			return "No games are muted", "", nil
+		}
+		return "Muted games:\n" + strings.Join(names, "\n"), "", nil
+	}
+
+	name := strings.Join(args, " ")
+	if err := muteGame(bc.db, name, evt.Sender.String()); err != nil {
+		return "", "", err
+	}
+	return fmt.Sprintf("🔇 Muted %q", name), "", nil
+}
+
+// cmdUnmute removes a muted game
+func (bc *BotCommands) cmdUnmute(evt *event.Event, args []string) (string, string, error) {
+	name := strings.Join(args, " ")
+	removed, err := unmuteGame(bc.db, name)
+	if err != nil {
+		return "", "", err
+	}
+	if !removed {
+		return fmt.Sprintf("%q is not muted", name), "", nil
+	}
+	return fmt.Sprintf("🔔 Unmuted %q", name), "", nil
+}
+
+// findBotCommand returns the command with the given name, or nil
+func findBotCommand(name string) *botCommand {
+	for i := range botCommands {
+		if botCommands[i].name == name {
+			return &botCommands[i]
+		}
+	}
+	return nil
+}
+
+// parseCommandLine splits "!name arg ..." into the lower-cased command name and its arguments.
+// Arguments may be quoted with single or double quotes, and a backslash escapes the next character.
+func parseCommandLine(line string) (string, []string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg, escaped := false, false
+	for _, r := range strings.TrimSpace(line) {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return "", nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 || !strings.HasPrefix(args[0], "!") || len(args[0]) == 1 {
+		return "", nil, fmt.Errorf("not a command")
+	}
+	return strings.ToLower(strings.TrimPrefix(args[0], "!")), args[1:], nil
+}
+
+// parseEventRef accepts an event ID or a matrix.to link to an event
+func parseEventRef(ref string) string {
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		ref = unescaped
+	}
+	if i := strings.LastIndex(ref, "$"); i > 0 {
+		ref = ref[i:]
+	}
+	if i := strings.IndexAny(ref, "?/"); i > 0 {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// releaseStatusIcon returns a short marker for a release status
+func releaseStatusIcon(status string) string {
+	switch status {
+	case ReleaseStatusSent:
+		return "✅"
+	case ReleaseStatusPending:
+		return "⏳"
+	case ReleaseStatusSkipped:
+		return "🔇"
+	case ReleaseStatusDead:
+		return "❌"
+	}
+	return status
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/event"
+	mautrixID "maunium.net/go/mautrix/id"
+)
+
+const testCommandRoom = mautrixID.RoomID("!room:example.org")
+
+// fakeHomeserver answers the power levels request and records the messages the bot sends
+// and the events it redacts. Sends to failRooms are refused.
+type fakeHomeserver struct {
+	mu        sync.Mutex
+	levels    map[string]int
+	failRooms map[string]bool
+	sent      []event.MessageEventContent
+	redacted  []string
+}
+
+// roomOf returns the room ID of a /rooms/{roomId}/... request path
+func roomOf(path string) string {
+	_, rest, _ := strings.Cut(path, "/rooms/")
+	room, _, _ := strings.Cut(rest, "/")
+	return room
+}
+
+func (hs *fakeHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/state/m.room.power_levels"):
+		json.NewEncoder(w).Encode(map[string]interface{}{"users": hs.levels, "users_default": 0})
+	case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/send/m.room.message/"):
+		if hs.failRooms[roomOf(r.URL.Path)] {
+			http.Error(w, `{"errcode":"M_FORBIDDEN","error":"not in room"}`, http.StatusForbidden)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var content event.MessageEventContent
+		json.Unmarshal(body, &content)
+		hs.sent = append(hs.sent, content)
+		json.NewEncoder(w).Encode(map[string]string{"event_id": fmt.Sprintf("$sent-%d", len(hs.sent))})
+	case r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/redact/"):
+		_, rest, _ := strings.Cut(r.URL.Path, "/redact/")
+		eventID, _, _ := strings.Cut(rest, "/")
+		hs.redacted = append(hs.redacted, roomOf(r.URL.Path)+" "+eventID)
+		json.NewEncoder(w).Encode(map[string]string{"event_id": "$redaction"})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (hs *fakeHomeserver) replies() []event.MessageEventContent {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return append([]event.MessageEventContent(nil), hs.sent...)
+}
+
+func (hs *fakeHomeserver) redactions() []string {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	return append([]string(nil), hs.redacted...)
+}
+
+// newTestBotCommands creates a command handler talking to a fake homeserver; allowed
+// users may run commands, and so may users with power level 50 or more
+func newTestBotCommands(t *testing.T, allowed ...string) (*BotCommands, *fakeHomeserver) {
+	t.Helper()
+	hs := &fakeHomeserver{levels: map[string]int{"@mod:example.org": 50, "@user:example.org": 0}}
+	srv := httptest.NewServer(hs)
+	t.Cleanup(srv.Close)
+
+	client, err := mautrix.NewClient(srv.URL, "@bot:example.org", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{MatrixRoomID: string(testCommandRoom), MatrixCommandUsers: allowed, MatrixCommandLevel: 50}
+	rp := &RSSProcessor{config: cfg, reloaded: make(chan struct{}), matrixClient: &MatrixClient{client: client}}
+	return NewBotCommands(context.Background(), rp, newTestDB(t)), hs
+}
+
+func commandEvent(sender, body string) *event.Event {
+	evt := &event.Event{
+		Sender:    mautrixID.UserID(sender),
+		Type:      event.EventMessage,
+		RoomID:    testCommandRoom,
+		ID:        "$command",
+		Timestamp: time.Now().Add(time.Minute).UnixMilli(),
+	}
+	evt.Content.Parsed = &event.MessageEventContent{MsgType: event.MsgText, Body: body}
+	return evt
+}
+
+func TestBotCommandsIgnoreUnauthorizedUsers(t *testing.T) {
+	for _, body := range []string{"!help", "!nosuchcommand", `!search "unterminated`, "!last 1 2 3"} {
+		bc, hs := newTestBotCommands(t)
+		bc.runCommand(commandEvent("@user:example.org", body))
+		if got := hs.replies(); len(got) != 0 {
+			t.Errorf("%s from an unauthorized user got replies %+v", body, got)
+		}
+	}
+}
+
+func TestBotCommandsReplies(t *testing.T) {
+	cases := []struct {
+		sender, body, want string
+	}{
+		{"@mod:example.org", "!help", "Available commands:"},
+		{"@mod:example.org", "!LAST", "No releases yet"},
+		{"@mod:example.org", "!nosuchcommand", "Unknown command !nosuchcommand"},
+		{"@mod:example.org", "!last 1 2", "Usage: !last [count]"},
+		{"@mod:example.org", "!last zero", "count must be a number"},
+		{"@mod:example.org", `!mute "Half-Life 3`, "unterminated"},
+		{"@mod:example.org", "!mute Half-Life 3", `Muted "Half-Life 3"`},
+	}
+	for _, tc := range cases {
+		bc, hs := newTestBotCommands(t)
+		bc.runCommand(commandEvent(tc.sender, tc.body))
+		got := hs.replies()
+		if len(got) != 1 {
+			t.Errorf("%s: got %d replies, want 1", tc.body, len(got))
+			continue
+		}
+		if !strings.Contains(got[0].Body, tc.want) {
+			t.Errorf("%s: reply %q does not contain %q", tc.body, got[0].Body, tc.want)
+		}
+		if got[0].MsgType != event.MsgNotice || got[0].RelatesTo == nil || got[0].RelatesTo.GetReplyTo() != "$command" {
+			t.Errorf("%s: reply is not a notice replying to the command: %+v", tc.body, got[0])
+		}
+	}
+}
+
+func TestBotCommandsAllowList(t *testing.T) {
+	bc, hs := newTestBotCommands(t, "@user:example.org")
+	bc.runCommand(commandEvent("@mod:example.org", "!help"))
+	bc.runCommand(commandEvent("@user:example.org", "!help"))
+	if got := hs.replies(); len(got) != 1 {
+		t.Fatalf("got %d replies, want only the allow-listed user's", len(got))
+	}
+}
+
+func TestBotCommandsHandleEventQueues(t *testing.T) {
+	bc, hs := newTestBotCommands(t)
+
+	old := commandEvent("@mod:example.org", "!help")
+	old.Timestamp = bc.startedAt.Add(-time.Minute).UnixMilli()
+	otherRoom := commandEvent("@mod:example.org", "!help")
+	otherRoom.RoomID = "!other:example.org"
+	for _, evt := range []*event.Event{
+		commandEvent("@mod:example.org", "hello"),
+		commandEvent("@bot:example.org", "!help"),
+		old,
+		otherRoom,
+	} {
+		bc.handleEvent(mautrix.EventSourceTimeline, evt)
+	}
+	if len(bc.queue) != 0 {
+		t.Fatalf("queued %d events that are not commands for the bot", len(bc.queue))
+	}
+
+	// handleEvent only queues; nothing is sent until Serve runs
+	bc.handleEvent(mautrix.EventSourceTimeline, commandEvent("@mod:example.org", "!help"))
+	if len(bc.queue) != 1 || len(hs.replies()) != 0 {
+		t.Fatalf("queue = %d, replies = %d; want the command queued and unanswered", len(bc.queue), len(hs.replies()))
+	}
+	for i := 0; i < commandQueueSize+5; i++ {
+		bc.handleEvent(mautrix.EventSourceTimeline, commandEvent("@mod:example.org", "!help"))
+	}
+	if len(bc.queue) != commandQueueSize {
+		t.Fatalf("queue = %d, want it capped at %d", len(bc.queue), commandQueueSize)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	bc.ctx = ctx
+	done := make(chan struct{})
+	go func() {
+		bc.Serve()
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(hs.replies()) < commandQueueSize && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if got := len(hs.replies()); got != commandQueueSize {
+		t.Errorf("Serve answered %d commands, want %d", got, commandQueueSize)
+	}
+}
+
+func TestBotCommandsRematchKeepsFailedRooms(t *testing.T) {
+	bc, hs := newTestBotCommands(t)
+	hs.failRooms = map[string]bool{"!b:example.org": true}
+	bc.rp.config.Routes = []*RoomRoute{{Name: "b", RoomID: "!b:example.org"}}
+	cache := NewIGDBCache(bc.db, time.Hour, time.Hour)
+	if err := cache.Store("Portal 2", &IGDBGameInfo{ID: 72, Title: "Portal 2"}); err != nil {
+		t.Fatal(err)
+	}
+	bc.rp.igdbClient = &IGDBClient{cache: cache}
+
+	payload, _ := json.Marshal(outboxPayload{
+		Release:    &Release{GUID: "g", Title: "Portal.2-RUNE"},
+		Deliveries: map[string][]string{"!a:example.org": {"$old-a"}, "!b:example.org": {"$old-b"}},
+	})
+	rec := &ReleaseRecord{GUID: "g", Feed: "zamunda", RawTitle: "Portal.2-RUNE", ExtractedName: "Portal 2",
+		RoomID: "!a:example.org", EventIDs: []string{"$old-a", "$old-b"}, Status: ReleaseStatusSent, Payload: string(payload)}
+	if err := saveRelease(bc.db, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.runCommand(commandEvent("@mod:example.org", "!rematch $old-a 72"))
+	sent := hs.replies()
+	if len(sent) != 2 || !strings.Contains(sent[1].Body, "Rematched") || !strings.Contains(sent[1].Body, "failed to send to !b:example.org") {
+		t.Fatalf("sent %+v; want the new notification and a reply naming the failed room", sent)
+	}
+	// Only the room that got the new notification loses its old one
+	if got := hs.redactions(); len(got) != 1 || got[0] != "!a:example.org $old-a" {
+		t.Errorf("redacted %q, want only $old-a", got)
+	}
+
+	updated, err := releaseByEventID(bc.db, "$sent-1")
+	if err != nil || updated == nil {
+		t.Fatalf("releaseByEventID($sent-1) = %v, %v; want the release to know its new event", updated, err)
+	}
+	var resent outboxPayload
+	json.Unmarshal([]byte(updated.Payload), &resent)
+	if got := resent.Deliveries; len(got) != 2 || got["!a:example.org"][0] != "$sent-1" || got["!b:example.org"][0] != "$old-b" {
+		t.Errorf("deliveries = %v, want the new event in !a and the old one kept in !b", got)
+	}
+	if updated.IGDBID != 72 {
+		t.Errorf("IGDB ID = %d, want 72", updated.IGDBID)
+	}
+}
+
+func TestBotCommandsRematchAllRoomsFailed(t *testing.T) {
+	bc, hs := newTestBotCommands(t)
+	hs.failRooms = map[string]bool{"!a:example.org": true}
+	cache := NewIGDBCache(bc.db, time.Hour, time.Hour)
+	if err := cache.Store("Portal 2", &IGDBGameInfo{ID: 72, Title: "Portal 2"}); err != nil {
+		t.Fatal(err)
+	}
+	bc.rp.igdbClient = &IGDBClient{cache: cache}
+	payload, _ := json.Marshal(outboxPayload{Release: &Release{GUID: "g", Title: "Portal.2-RUNE"}})
+	rec := &ReleaseRecord{GUID: "g", Feed: "zamunda", RawTitle: "Portal.2-RUNE", ExtractedName: "Portal 2",
+		RoomID: "!a:example.org", EventIDs: []string{"$old-a"}, Status: ReleaseStatusSent, Payload: string(payload)}
+	if err := saveRelease(bc.db, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	bc.runCommand(commandEvent("@mod:example.org", "!rematch $old-a 72"))
+	if sent := hs.replies(); len(sent) != 1 || !strings.Contains(sent[0].Body, "failed to send notification to !a:example.org") {
+		t.Errorf("sent %+v; want only an error reply", sent)
+	}
+	if got := hs.redactions(); len(got) != 0 {
+		t.Errorf("redacted %q after every send failed", got)
+	}
+	if unchanged, _ := releaseByEventID(bc.db, "$old-a"); unchanged == nil || unchanged.IGDBID != 0 {
+		t.Errorf("release = %+v, want it unchanged", unchanged)
+	}
+}
+
+func TestParseCommandLine(t *testing.T) {
+	name, args, err := parseCommandLine(`!Search "Half-Life 2" it\'s`)
+	if err != nil || name != "search" || len(args) != 2 || args[0] != "Half-Life 2" || args[1] != "it's" {
+		t.Errorf("got %q %q %v", name, args, err)
+	}
+	if _, _, err := parseCommandLine("!"); err == nil {
+		t.Error("expected an error for a bare !")
+	}
+}
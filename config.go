@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigFiles are tried in order when CONFIG_FILE is not set
+var defaultConfigFiles = []string{"config.yaml", "config.yml"}
+
+// configPollInterval is how often the config file and .env are checked for changes
+const configPollInterval = 5 * time.Second
+
+// configFileKeys maps the keys of the config file to the environment variables they set
+var configFileKeys = map[string]string{
+	"jackett.url":                 "JACKETT_URL",
+	"jackett.api_key":             "JACKETT_API_KEY",
+	"jackett.indexers":            "JACKETT_INDEXERS",
+	"jackett.categories":          "TORZNAB_CATEGORIES",
+	"poll_interval":               "POLL_INTERVAL",
+	"log_level":                   "LOG_LEVEL",
+	"matrix.homeserver":           "MATRIX_HOMESERVER",
+	"matrix.user_id":              "MATRIX_USER_ID",
+	"matrix.user":                 "MATRIX_USER",
+	"matrix.password":             "MATRIX_PASSWORD",
+	"matrix.access_token":         "MATRIX_ACCESS_TOKEN",
+	"matrix.room_id":              "MATRIX_ROOM_ID",
+	"matrix.device_id":            "MATRIX_DEVICE_ID",
+	"matrix.encryption":           "MATRIX_ENCRYPTION",
+	"matrix.pickle_key":           "MATRIX_PICKLE_KEY",
+	"matrix.recovery_key":         "MATRIX_RECOVERY_KEY",
+	"matrix.commands":             "MATRIX_COMMANDS",
+	"matrix.command_users":        "MATRIX_COMMAND_USERS",
+	"matrix.command_power_level":  "MATRIX_COMMAND_POWER_LEVEL",
+	"matrix.verbosity":            "MATRIX_VERBOSITY",
+	"matrix.screenshots":          "MATRIX_SCREENSHOTS",
+	"matrix.gallery":              "MATRIX_GALLERY",
+	"matrix.templates":            "MATRIX_TEMPLATES",
+	"images.cover_thumbnail":      "THUMBNAIL_COVER",
+	"images.screenshot_thumbnail": "THUMBNAIL_SCREENSHOT",
+	"images.jpeg_quality":         "THUMBNAIL_JPEG_QUALITY",
+	"images.timeout":              "IMAGE_TIMEOUT",
+	"images.max_bytes":            "IMAGE_MAX_BYTES",
+	"images.max_pixels":           "IMAGE_MAX_PIXELS",
+	"images.retries":              "IMAGE_RETRIES",
+	"igdb.client_id":              "IGDB_CLIENT_ID",
+	"igdb.client_secret":          "IGDB_CLIENT_SECRET",
+	"igdb.cache_ttl":              "IGDB_CACHE_TTL",
+	"igdb.negative_cache_ttl":     "IGDB_NEGATIVE_CACHE_TTL",
+	"outbox.max_attempts":         "OUTBOX_MAX_ATTEMPTS",
+	"outbox.retry_backoff":        "OUTBOX_RETRY_BACKOFF",
+	"dedupe.window":               "DEDUPE_WINDOW",
+	"dedupe.mode":                 "DEDUPE_MODE",
+}
+
+// feedFileKeys and routeFileKeys are the keys of the entries of "feeds" and "routes",
+// which set FEED_<NAME>_* and ROUTE_<NAME>_* variables
+var (
+	feedFileKeys  = []string{"indexer", "url", "api_key", "categories", "interval", "profile", "room"}
+	routeFileKeys = []string{"room", "feeds", "genres", "platforms", "groups", "min_rating", "title", "verbosity", "screenshots", "gallery", "templates"}
+)
+
+// restartSettings are only used to set up the Matrix and IGDB clients, so reloads
+// cannot apply them
+var restartSettings = []struct {
+	key   string
+	value func(*Config) interface{}
+}{
+	{"MATRIX_HOMESERVER", func(c *Config) interface{} { return c.MatrixHomeserver }},
+	{"MATRIX_USER_ID", func(c *Config) interface{} { return c.MatrixUserID }},
+	{"MATRIX_USER", func(c *Config) interface{} { return c.MatrixUser }},
+	{"MATRIX_PASSWORD", func(c *Config) interface{} { return c.MatrixPassword }},
+	{"MATRIX_ACCESS_TOKEN", func(c *Config) interface{} { return c.MatrixAccessToken }},
+	{"MATRIX_ROOM_ID", func(c *Config) interface{} { return c.MatrixRoomID }},
+	{"MATRIX_DEVICE_ID", func(c *Config) interface{} { return c.MatrixDeviceID }},
+	{"MATRIX_ENCRYPTION", func(c *Config) interface{} { return c.MatrixEncryption }},
+	{"MATRIX_PICKLE_KEY", func(c *Config) interface{} { return c.MatrixPickleKey }},
+	{"MATRIX_RECOVERY_KEY", func(c *Config) interface{} { return c.MatrixRecoveryKey }},
+	{"MATRIX_COMMANDS", func(c *Config) interface{} { return c.MatrixCommands }},
+	{"MATRIX_COMMAND_USERS", func(c *Config) interface{} { return c.MatrixCommandUsers }},
+	{"MATRIX_COMMAND_POWER_LEVEL", func(c *Config) interface{} { return c.MatrixCommandLevel }},
+	{"THUMBNAIL_COVER", func(c *Config) interface{} { return c.CoverThumbnail }},
+	{"THUMBNAIL_SCREENSHOT", func(c *Config) interface{} { return c.ScreenshotThumbnail }},
+	{"THUMBNAIL_JPEG_QUALITY", func(c *Config) interface{} { return c.JPEGQuality }},
+	{"IMAGE_TIMEOUT", func(c *Config) interface{} { return c.ImageTimeout }},
+	{"IMAGE_MAX_BYTES", func(c *Config) interface{} { return c.ImageMaxBytes }},
+	{"IMAGE_MAX_PIXELS", func(c *Config) interface{} { return c.ImageMaxPixels }},
+	{"IMAGE_RETRIES", func(c *Config) interface{} { return c.ImageRetries }},
+	{"IGDB_CLIENT_ID", func(c *Config) interface{} { return c.IGDBClientID }},
+	{"IGDB_CLIENT_SECRET", func(c *Config) interface{} { return c.IGDBClientSecret }},
+	{"IGDB_CACHE_TTL", func(c *Config) interface{} { return c.IGDBCacheTTL }},
+	{"IGDB_NEGATIVE_CACHE_TTL", func(c *Config) interface{} { return c.IGDBNegativeCacheTTL }},
+}
+
+// configSource looks settings up by environment variable name: in the environment first,
+// then in .env, then in the config file. A <NAME>_FILE variable in the environment or .env
+// sets <NAME> to the contents of that file, for secrets such as Docker and systemd credentials.
+type configSource struct {
+	path          string                 // config file, empty when none is used
+	dotenv        map[string]string      // settings from .env
+	envSecrets    map[string]string      // settings read from <NAME>_FILE files named in the environment
+	dotenvSecrets map[string]string      // settings read from <NAME>_FILE files named in .env
+	file          map[string]fileSetting // settings from the config file by variable name
+}
+
+// fileSetting is a value from the config file and the key it was set with
+type fileSetting struct {
+	key   string
+	value string
+}
+
+// configFilePath returns the config file to read: CONFIG_FILE, or the first of
+// defaultConfigFiles that exists
+func configFilePath() string {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		return path
+	}
+	for _, path := range defaultConfigFiles {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// newConfigSource reads .env and the config file
+func newConfigSource() (*configSource, error) {
+	src := &configSource{path: configFilePath()}
+
+	dotenv, err := godotenv.Read()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read .env: %v", err)
+	}
+	src.dotenv = dotenv
+
+	environ := map[string]string{}
+	for _, kv := range os.Environ() {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			environ[key] = value
+		}
+	}
+	if src.envSecrets, err = readSecretFiles(environ); err != nil {
+		return nil, err
+	}
+	if src.dotenvSecrets, err = readSecretFiles(dotenv); err != nil {
+		return nil, err
+	}
+
+	if src.path != "" {
+		data, err := os.ReadFile(src.path)
+		if err != nil {
+			return nil, err
+		}
+		if src.file, err = parseConfigFile(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", src.path, err)
+		}
+	}
+	return src, nil
+}
+
+// get returns a setting, or defaultValue when it is not set anywhere
+func (s *configSource) get(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	if value := s.envSecrets[key]; value != "" {
+		return value
+	}
+	if value := s.dotenv[key]; value != "" {
+		return value
+	}
+	if value := s.dotenvSecrets[key]; value != "" {
+		return value
+	}
+	if setting, ok := s.file[key]; ok && setting.value != "" {
+		return setting.value
+	}
+	return defaultValue
+}
+
+// name returns how errors refer to a setting: by its config file key when the file
+// defines it and nothing overrides it, otherwise by its variable name
+func (s *configSource) name(key string) string {
+	if os.Getenv(key) != "" {
+		return key
+	}
+	if s.envSecrets[key] != "" {
+		return key + "_FILE"
+	}
+	if s.dotenv[key] != "" {
+		return key
+	}
+	if s.dotenvSecrets[key] != "" {
+		return key + "_FILE"
+	}
+	if setting, ok := s.file[key]; ok {
+		return fmt.Sprintf("%s (%s)", setting.key, s.path)
+	}
+	if s.path != "" {
+		for fileKey, envKey := range configFileKeys {
+			if envKey == key {
+				return fmt.Sprintf("%s (%s)", fileKey, s.path)
+			}
+		}
+	}
+	return key
+}
+
+// readSecretFiles reads the files named by the <NAME>_FILE variables of vars, where <NAME> is
+// a setting, returning their contents by <NAME>. A trailing newline is dropped.
+func readSecretFiles(vars map[string]string) (map[string]string, error) {
+	secrets := map[string]string{}
+	for key, path := range vars {
+		name, ok := strings.CutSuffix(key, "_FILE")
+		if !ok || path == "" || !isSettingName(name) {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", key, err)
+		}
+		secrets[name] = strings.TrimRight(string(data), "\r\n")
+	}
+	return secrets, nil
+}
+
+// isSettingName reports whether name is a setting that loadConfig reads
+func isSettingName(name string) bool {
+	if name == "FEEDS" || name == "ROUTES" || strings.HasPrefix(name, "FEED_") || strings.HasPrefix(name, "ROUTE_") {
+		return true
+	}
+	for _, envKey := range configFileKeys {
+		if envKey == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfigFile reads a YAML config file into settings keyed by the environment
+// variables they correspond to. Unknown keys and values of the wrong shape are errors.
+func parseConfigFile(data []byte) (map[string]fileSetting, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid YAML: %v", err)
+	}
+	root := map[string]interface{}{}
+	if len(doc.Content) > 0 {
+		value, err := yamlValue("", doc.Content[0])
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if root, ok = value.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("the config file must be a mapping of keys to values")
+		}
+	}
+
+	settings := map[string]fileSetting{}
+	set := func(key, envKey string, value interface{}) error {
+		s, err := configFileValue(key, value)
+		if err != nil {
+			return err
+		}
+		settings[envKey] = fileSetting{key: key, value: s}
+		return nil
+	}
+
+	var walk func(prefix string, obj map[string]interface{}) error
+	walk = func(prefix string, obj map[string]interface{}) error {
+		for _, name := range sortedKeys(obj) {
+			key, value := prefix+name, obj[name]
+			switch {
+			case key == "feeds" || key == "routes":
+				if err := parseConfigList(key, value, settings, set); err != nil {
+					return err
+				}
+			case configFileKeys[key] != "":
+				if err := set(key, configFileKeys[key], value); err != nil {
+					return err
+				}
+			default:
+				section, ok := value.(map[string]interface{})
+				if !ok || !isConfigSection(key) {
+					return fmt.Errorf("unknown key %s", key)
+				}
+				if err := walk(key+".", section); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk("", root); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// parseConfigList reads the "feeds" or "routes" list. Each entry needs a unique name and
+// sets the FEED_<NAME>_* or ROUTE_<NAME>_* variables; the names set FEEDS or ROUTES.
+func parseConfigList(list string, value interface{}, settings map[string]fileSetting, set func(key, envKey string, value interface{}) error) error {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be a list", list)
+	}
+	envList, envPrefix, fields := "FEEDS", "FEED_", feedFileKeys
+	if list == "routes" {
+		envList, envPrefix, fields = "ROUTES", "ROUTE_", routeFileKeys
+	}
+
+	var names []string
+	for i, entry := range entries {
+		obj, ok := entry.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s[%d] must be an object", list, i)
+		}
+		name, _ := obj["name"].(string)
+		if strings.TrimSpace(name) == "" || strings.Contains(name, ",") {
+			return fmt.Errorf("%s[%d].name is required and must not contain commas", list, i)
+		}
+		names = append(names, name)
+
+		prefix := envPrefix + feedEnvKey(name) + "_"
+		for _, field := range sortedKeys(obj) {
+			if field == "name" {
+				continue
+			}
+			if !containsString(fields, field) {
+				return fmt.Errorf("unknown key %s.%s.%s", list, name, field)
+			}
+			if err := set(list+"."+name+"."+field, prefix+strings.ToUpper(field), obj[field]); err != nil {
+				return err
+			}
+		}
+		// Name unset fields too, so errors about missing values point into the file
+		for _, field := range fields {
+			if _, ok := settings[prefix+strings.ToUpper(field)]; !ok {
+				settings[prefix+strings.ToUpper(field)] = fileSetting{key: list + "." + name + "." + field}
+			}
+		}
+	}
+	settings[envList] = fileSetting{key: list, value: strings.Join(names, ",")}
+	return nil
+}
+
+// yamlValue converts a YAML node into maps, lists and scalars. Scalars are kept as written,
+// so "1m", "0.5" and "true" reach the settings as the same text an environment variable holds.
+func yamlValue(key string, node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return yamlValue(key, node.Alias)
+	case yaml.MappingNode:
+		obj := map[string]interface{}{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			childKey := name
+			if key != "" {
+				childKey = key + "." + name
+			}
+			if node.Content[i].Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("%s: keys must be plain values", key)
+			}
+			if _, dup := obj[name]; dup {
+				return nil, fmt.Errorf("duplicate key %s", childKey)
+			}
+			value, err := yamlValue(childKey, node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			obj[name] = value
+		}
+		return obj, nil
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(node.Content))
+		for i, item := range node.Content {
+			value, err := yamlValue(fmt.Sprintf("%s[%d]", key, i), item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return nil, nil
+		}
+		return node.Value, nil
+	}
+	return nil, fmt.Errorf("%s has an unsupported value", key)
+}
+
+// configFileValue turns a config file value into the string an environment variable would hold.
+// Lists of values become comma-separated lists.
+func configFileValue(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for i, item := range v {
+			s, err := configFileValue(fmt.Sprintf("%s[%d]", key, i), item)
+			if err != nil {
+				return "", err
+			}
+			if _, isList := item.([]interface{}); isList || strings.Contains(s, ",") {
+				return "", fmt.Errorf("%s[%d] must be a single value without commas", key, i)
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, ","), nil
+	default:
+		return "", fmt.Errorf("%s must be a value or a list of values", key)
+	}
+}
+
+// isConfigSection reports whether key is a section of the config file, such as "matrix"
+func isConfigSection(key string) bool {
+	for fileKey := range configFileKeys {
+		if strings.HasPrefix(fileKey, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a map in order, so errors are reported consistently
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// currentConfig returns the configuration in effect. Reloads replace it, so read it once per operation.
+func (rp *RSSProcessor) currentConfig() *Config {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	return rp.config
+}
+
+// configReloaded returns a channel that is closed when the configuration is next replaced
+func (rp *RSSProcessor) configReloaded() <-chan struct{} {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+	return rp.reloaded
+}
+
+// setConfig replaces the configuration and wakes everything waiting on configReloaded
+func (rp *RSSProcessor) setConfig(cfg *Config) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.config = cfg
+	setLogLevel(cfg.LogLevel)
+	close(rp.reloaded)
+	rp.reloaded = make(chan struct{})
+}
+
+// reloadConfig loads the configuration again and applies it. An invalid configuration is
+// logged and the current one kept. Settings used to set up the clients need a restart.
+func (rp *RSSProcessor) reloadConfig() {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Printf("Failed to reload configuration, keeping the current one: %v", err)
+		return
+	}
+	old := rp.currentConfig()
+	for _, setting := range restartSettings {
+		if !reflect.DeepEqual(setting.value(old), setting.value(cfg)) {
+			log.Printf("%s changed; restart to apply it", setting.key)
+		}
+	}
+	rp.setConfig(cfg)
+	if rp.matrixClient != nil {
+		known := map[string]bool{}
+		for _, roomID := range configRoomIDs(old) {
+			known[roomID] = true
+		}
+		var added []string
+		for _, roomID := range configRoomIDs(cfg) {
+			if !known[roomID] {
+				added = append(added, roomID)
+			}
+		}
+		rp.matrixClient.PreloadRooms(added)
+	}
+	log.Printf("Configuration reloaded: %d feeds, %d routes", len(cfg.Feeds), len(cfg.Routes))
+}
+
+// configRoomIDs returns every room the configuration sends to: the default room and the
+// rooms of the feeds and routes, each once
+func configRoomIDs(cfg *Config) []string {
+	var rooms []string
+	seen := map[string]bool{"": true}
+	add := func(roomID string) {
+		if !seen[roomID] {
+			seen[roomID] = true
+			rooms = append(rooms, roomID)
+		}
+	}
+	add(cfg.MatrixRoomID)
+	for _, feed := range cfg.Feeds {
+		add(feed.RoomID)
+	}
+	for _, route := range cfg.Routes {
+		add(route.RoomID)
+	}
+	return rooms
+}
+
+// watchConfig reloads the configuration on SIGHUP and when the config file or .env changes,
+// until ctx is cancelled
+func (rp *RSSProcessor) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	stamp := configFilesStamp()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("Received SIGHUP, reloading configuration")
+			stamp = configFilesStamp()
+			rp.reloadConfig()
+		case <-ticker.C:
+			if current := configFilesStamp(); current != stamp {
+				log.Println("Configuration files changed, reloading configuration")
+				stamp = current
+				rp.reloadConfig()
+			}
+		}
+	}
+}
+
+// configFilesStamp describes the modification times and sizes of the config file and .env,
+// so that a change to either shows up as a different stamp
+func configFilesStamp() string {
+	var stamp strings.Builder
+	for _, path := range []string{configFilePath(), ".env"} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&stamp, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return stamp.String()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testConfig is a complete config file; tests append or replace lines to break it
+const testConfig = `
+jackett:
+  url: http://localhost:9117
+  categories: [4000, 4050]
+poll_interval: 1m
+feeds:
+  - name: zamunda
+    indexer: zamunda
+    interval: 5m
+matrix:
+  homeserver: https://matrix.example.org
+  user_id: "@bot:example.org"
+  access_token: secret
+  room_id: "!room:example.org"
+igdb:
+  client_id: id
+  client_secret: secret
+`
+
+// useTestConfig writes a config file into an empty working directory, so no .env or
+// config.yaml of the developer is read, and points CONFIG_FILE at it
+func useTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	path := filepath.Join(dir, "config.yaml")
+	writeTestConfig(t, path, content)
+	t.Setenv("CONFIG_FILE", path)
+	return path
+}
+
+func writeTestConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseConfigFile(t *testing.T) {
+	settings, err := parseConfigFile([]byte(testConfig + `
+images:
+  max_bytes: 0010
+  timeout: ~
+routes:
+  - name: rpg
+    room: "!rpg:example.org"
+    genres: [RPG, Strategy]
+    gallery: true
+`))
+	if err != nil {
+		t.Fatalf("parseConfigFile: %v", err)
+	}
+	want := map[string]fileSetting{
+		"JACKETT_URL":          {"jackett.url", "http://localhost:9117"},
+		"TORZNAB_CATEGORIES":   {"jackett.categories", "4000,4050"},
+		"MATRIX_USER_ID":       {"matrix.user_id", "@bot:example.org"},
+		"IMAGE_MAX_BYTES":      {"images.max_bytes", "0010"},
+		"IMAGE_TIMEOUT":        {"images.timeout", ""},
+		"FEEDS":                {"feeds", "zamunda"},
+		"FEED_ZAMUNDA_INDEXER": {"feeds.zamunda.indexer", "zamunda"},
+		"FEED_ZAMUNDA_URL":     {"feeds.zamunda.url", ""},
+		"ROUTES":               {"routes", "rpg"},
+		"ROUTE_RPG_GENRES":     {"routes.rpg.genres", "RPG,Strategy"},
+		"ROUTE_RPG_GALLERY":    {"routes.rpg.gallery", "true"},
+	}
+	for envKey, setting := range want {
+		if got := settings[envKey]; got != setting {
+			t.Errorf("%s = %+v, want %+v", envKey, got, setting)
+		}
+	}
+}
+
+func TestParseConfigFileJSON(t *testing.T) {
+	settings, err := parseConfigFile([]byte(`{"matrix": {"room_id": "!room:example.org", "screenshots": 3}}`))
+	if err != nil {
+		t.Fatalf("parseConfigFile: %v", err)
+	}
+	if settings["MATRIX_ROOM_ID"].value != "!room:example.org" || settings["MATRIX_SCREENSHOTS"].value != "3" {
+		t.Errorf("got %+v", settings)
+	}
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	cases := []struct {
+		name, content, want string
+	}{
+		{"unknown key", "matrix:\n  rooom_id: x\n", "unknown key matrix.rooom_id"},
+		{"unknown section", "jacket:\n  url: x\n", "unknown key jacket"},
+		{"duplicate key", "matrix:\n  room_id: a\n  room_id: b\n", "duplicate key matrix.room_id"},
+		{"section as value", "matrix: x\n", "unknown key matrix"},
+		{"mapping as value", "poll_interval:\n  minutes: 1\n", "poll_interval must be a value or a list of values"},
+		{"nested list", "jackett:\n  categories: [[4000]]\n", "jackett.categories[0] must be a single value without commas"},
+		{"comma in list", "jackett:\n  categories: [\"4000,4050\"]\n", "jackett.categories[0] must be a single value without commas"},
+		{"feeds not a list", "feeds:\n  name: zamunda\n", "feeds must be a list"},
+		{"feed without name", "feeds:\n  - indexer: zamunda\n", "feeds[0].name is required"},
+		{"unknown feed key", "feeds:\n  - name: zamunda\n    intervall: 5m\n", "unknown key feeds.zamunda.intervall"},
+		{"not a mapping", "- a\n- b\n", "must be a mapping"},
+		{"invalid YAML", "matrix: [\n", "invalid YAML"},
+	}
+	for _, tc := range cases {
+		_, err := parseConfigFile([]byte(tc.content))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestLoadConfigNamesFileKeys(t *testing.T) {
+	path := useTestConfig(t, testConfig)
+	if _, err := loadConfig(); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+
+	cases := []struct {
+		name, old, new, want string
+	}{
+		{"top-level key", "poll_interval: 1m", "poll_interval: soon", "invalid poll_interval (" + path + ")"},
+		{"feed key", "interval: 5m", "interval: soon", "invalid feeds.zamunda.interval (" + path + ")"},
+		{"missing key", "  client_id: id\n", "", "igdb.client_id (" + path + ") is required"},
+		{"missing feed key", "    indexer: zamunda\n", "", "feeds.zamunda.url (" + path + ") or jackett.url"},
+	}
+	for _, tc := range cases {
+		content := strings.Replace(testConfig, tc.old, tc.new, 1)
+		if tc.name == "missing feed key" {
+			content = strings.Replace(content, "  url: http://localhost:9117\n", "", 1)
+		}
+		writeTestConfig(t, path, content)
+		_, err := loadConfig()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+
+	// The environment overrides the file, so errors name the variable instead
+	writeTestConfig(t, path, testConfig)
+	t.Setenv("POLL_INTERVAL", "soon")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "invalid POLL_INTERVAL:") {
+		t.Errorf("got %v, want an error naming POLL_INTERVAL", err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := useTestConfig(t, testConfig)
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	rp := &RSSProcessor{config: cfg, reloaded: make(chan struct{})}
+
+	reloaded := rp.configReloaded()
+	writeTestConfig(t, path, strings.Replace(testConfig, "interval: 5m", "interval: 10m", 1)+`
+routes:
+  - name: rpg
+    room: "!rpg:example.org"
+    genres: [RPG]
+`)
+	rp.reloadConfig()
+	select {
+	case <-reloaded:
+	default:
+		t.Fatal("reload did not close the configReloaded channel")
+	}
+	cfg = rp.currentConfig()
+	if len(cfg.Feeds) != 1 || cfg.Feeds[0].Interval != 10*time.Minute || len(cfg.Routes) != 1 || cfg.Routes[0].RoomID != "!rpg:example.org" {
+		t.Fatalf("reloaded config has feeds %+v and routes %+v", cfg.Feeds, cfg.Routes)
+	}
+
+	// An invalid file keeps the configuration in effect
+	reloaded = rp.configReloaded()
+	writeTestConfig(t, path, testConfig+"unknown: true\n")
+	rp.reloadConfig()
+	select {
+	case <-reloaded:
+		t.Error("an invalid config file was applied")
+	default:
+	}
+	if rp.currentConfig() != cfg {
+		t.Error("an invalid config file replaced the configuration")
+	}
+}
+
+func TestReadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "matrix_password")
+	writeTestConfig(t, secret, "s3cret\n")
+
+	secrets, err := readSecretFiles(map[string]string{
+		"MATRIX_PASSWORD_FILE":    secret,
+		"IGDB_CLIENT_SECRET_FILE": "",
+		"HISTFILE":                secret,
+		"SOME_OTHER_FILE":         secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || secrets["MATRIX_PASSWORD"] != "s3cret" {
+		t.Errorf("readSecretFiles = %q, want only MATRIX_PASSWORD without the newline", secrets)
+	}
+
+	_, err = readSecretFiles(map[string]string{"IGDB_CLIENT_SECRET_FILE": filepath.Join(dir, "missing")})
+	if err == nil || !strings.Contains(err.Error(), "IGDB_CLIENT_SECRET_FILE") {
+		t.Errorf("missing file: got %v, want an error naming IGDB_CLIENT_SECRET_FILE", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CredentialStore keeps credentials obtained at runtime, such as the Matrix access token from a
+// password login and the IGDB access token, in the database rather than in the user's config
+type CredentialStore struct {
+	db       *sql.DB
+	readOnly bool // Set and Delete do nothing
+}
+
+// NewCredentialStore creates a credential store
+func NewCredentialStore(db *sql.DB) *CredentialStore {
+	return &CredentialStore{db: db}
+}
+
+// ReadOnly returns a store that reads the same credentials but never changes them
+func (s *CredentialStore) ReadOnly() *CredentialStore {
+	return &CredentialStore{db: s.db, readOnly: true}
+}
+
+// Credential names; each is suffixed with the account it belongs to
+const (
+	credentialMatrixToken  = "matrix_access_token:" // + Matrix user ID
+	credentialMatrixDevice = "matrix_device_id:"    // + Matrix user ID
+	credentialIGDBToken    = "igdb_access_token:"   // + IGDB client ID
+)
+
+// Get returns a stored credential and when it expires (zero for never), or "" when there is
+// none or it has expired
+func (s *CredentialStore) Get(name string) (string, time.Time, error) {
+	if s == nil {
+		return "", time.Time{}, nil
+	}
+	var value string
+	var expiresAt int64
+	err := s.db.QueryRow(`SELECT value, expires_at FROM credentials WHERE name = ?`, name).Scan(&value, &expiresAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if expiresAt == 0 {
+		return value, time.Time{}, nil
+	}
+	expiry := time.Unix(expiresAt, 0)
+	if time.Now().After(expiry) {
+		return "", time.Time{}, nil
+	}
+	return value, expiry, nil
+}
+
+// Set stores a credential; a zero expiry means it does not expire
+func (s *CredentialStore) Set(name, value string, expiry time.Time) error {
+	if s == nil || s.readOnly {
+		return nil
+	}
+	var expiresAt int64
+	if !expiry.IsZero() {
+		expiresAt = expiry.Unix()
+	}
+	_, err := s.db.Exec(`INSERT INTO credentials (name, value, expires_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at, updated_at = excluded.updated_at`,
+		name, value, expiresAt, time.Now().Unix())
+	return err
+}
+
+// Delete removes a credential
+func (s *CredentialStore) Delete(name string) error {
+	if s == nil || s.readOnly {
+		return nil
+	}
+	_, err := s.db.Exec(`DELETE FROM credentials WHERE name = ?`, name)
+	return err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCredentialStore(t *testing.T) {
+	store := NewCredentialStore(newTestDB(t))
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := store.Set("token", "abc", expiry); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("expired", "old", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if value, exp, err := store.Get("token"); err != nil || value != "abc" || !exp.Equal(expiry) {
+		t.Errorf("Get(token) = %q, %v, %v", value, exp, err)
+	}
+	if value, _, err := store.Get("expired"); err != nil || value != "" {
+		t.Errorf("Get(expired) = %q, %v; want nothing", value, err)
+	}
+
+	// A read-only store sees the saved credentials but changes nothing
+	ro := store.ReadOnly()
+	if err := ro.Set("token", "new", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ro.Set("other", "x", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ro.Delete("token"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := ro.Get("token"); value != "abc" {
+		t.Errorf("read-only store changed the token to %q", value)
+	}
+	if value, _, _ := store.Get("other"); value != "" {
+		t.Errorf("read-only store saved %q", value)
+	}
+
+	if err := store.Delete("token"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _, err := store.Get("token"); err != nil || value != "" {
+		t.Errorf("Get(token) after Delete = %q, %v", value, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	mautrixID "maunium.net/go/mautrix/id"
+)
+
+// How a newer release of a recently posted game is shown
+const (
+	DedupeModeReply = "reply" // threaded reply to the earlier notification
+	DedupeModeEdit  = "edit"  // edit the earlier notification to show the newer release
+)
+
+// earlierNotification is the notification a newer release of the same game is attached to
+type earlierNotification struct {
+	rec     *ReleaseRecord
+	payload outboxPayload
+}
+
+// findEarlierNotification returns the notification of the game sent within DEDUPE_WINDOW before
+// the release, or nil when the release should get a notification of its own
+func (rp *RSSProcessor) findEarlierNotification(db *sql.DB, rec *ReleaseRecord, igdbInfo *IGDBGameInfo) *earlierNotification {
+	window := rp.currentConfig().DedupeWindow
+	if igdbInfo == nil || window <= 0 {
+		return nil
+	}
+	prev, err := previousGameRelease(db, igdbInfo.ID, rec, rec.CreatedAt.Add(-window))
+	if err != nil {
+		log.Printf("Failed to look up earlier releases of %s: %v", igdbInfo.Title, err)
+		return nil
+	}
+	if prev == nil {
+		return nil
+	}
+	earlier := &earlierNotification{rec: prev}
+	if err := json.Unmarshal([]byte(prev.Payload), &earlier.payload); err != nil {
+		// Releases recorded before the outbox have no payload, only their event IDs
+		earlier.payload = outboxPayload{}
+	}
+	return earlier
+}
+
+// rootIn returns the event in the room that updates attach to, or "" when the room has none
+func (n *earlierNotification) rootIn(roomID string) string {
+	if n == nil {
+		return ""
+	}
+	if root := n.payload.Roots[roomID]; root != "" {
+		return root
+	}
+	if eventIDs := n.payload.Deliveries[roomID]; len(eventIDs) > 0 {
+		return eventIDs[0]
+	}
+	if len(n.payload.Deliveries) == 0 && roomID == n.rec.RoomID && len(n.rec.EventIDs) > 0 {
+		return n.rec.EventIDs[0]
+	}
+	return ""
+}
+
+// sendUpdate shows a newer release of a game on its earlier notification, by editing it or by
+// replying in its thread. Failed edits, e.g. of notifications that were since deleted, fall back to a reply.
+func (rp *RSSProcessor) sendUpdate(ctx context.Context, route *RoomRoute, rootID string, release *Release, igdbInfo *IGDBGameInfo) ([]string, error) {
+	matrixClient := rp.matrixClient.ForRoom(route.RoomID)
+	if rp.currentConfig().DedupeMode == DedupeModeEdit {
+		eventID, err := matrixClient.EditGameNotification(ctx, mautrixID.EventID(rootID), igdbInfo, release, route)
+		if err == nil {
+			return []string{eventID.String()}, nil
+		}
+		log.Printf("Failed to edit notification %s, replying instead: %v", rootID, err)
+	}
+	eventID, err := matrixClient.SendReleaseUpdate(ctx, mautrixID.EventID(rootID), igdbInfo, release, route)
+	if err != nil {
+		return nil, err
+	}
+	return []string{eventID.String()}, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// saveTestRelease records a release of an IGDB game created age ago
+func saveTestRelease(t *testing.T, db *sql.DB, rec ReleaseRecord, age time.Duration) *ReleaseRecord {
+	t.Helper()
+	rec.Feed = "zamunda"
+	rec.CreatedAt = time.Now().Add(-age)
+	if err := saveRelease(db, &rec); err != nil {
+		t.Fatal(err)
+	}
+	return &rec
+}
+
+func TestFindEarlierNotification(t *testing.T) {
+	db := newTestDB(t)
+	saveTestRelease(t, db, ReleaseRecord{GUID: "old", IGDBID: 7, Status: ReleaseStatusSent}, 100*time.Hour)
+	saveTestRelease(t, db, ReleaseRecord{GUID: "sent", IGDBID: 7, Status: ReleaseStatusSent, RoomID: "!a:example.org",
+		Payload: `{"deliveries":{"!a:example.org":["$a1","$a2"]},"roots":{"!b:example.org":"$b"}}`}, 10*time.Hour)
+	saveTestRelease(t, db, ReleaseRecord{GUID: "failed", IGDBID: 7, Status: ReleaseStatusDead}, time.Hour)
+	saveTestRelease(t, db, ReleaseRecord{GUID: "other game", IGDBID: 8, Status: ReleaseStatusSent}, time.Hour)
+
+	rec := &ReleaseRecord{GUID: "new", CreatedAt: time.Now()}
+	info := &IGDBGameInfo{ID: 7, Title: "Starfield"}
+	rp := &RSSProcessor{config: &Config{DedupeWindow: 72 * time.Hour}}
+
+	earlier := rp.findEarlierNotification(db, rec, info)
+	if earlier == nil || earlier.rec.GUID != "sent" {
+		t.Fatalf("got %+v, want the release sent 10h ago", earlier)
+	}
+	for room, want := range map[string]string{"!a:example.org": "$a1", "!b:example.org": "$b", "!c:example.org": ""} {
+		if got := earlier.rootIn(room); got != want {
+			t.Errorf("rootIn(%s) = %q, want %q", room, got, want)
+		}
+	}
+
+	if got := rp.findEarlierNotification(db, rec, nil); got != nil {
+		t.Errorf("without IGDB info: got %+v, want nil", got.rec)
+	}
+	for _, window := range []time.Duration{0, 5 * time.Hour} {
+		rp.config.DedupeWindow = window
+		if got := rp.findEarlierNotification(db, rec, info); got != nil {
+			t.Errorf("window %s: got %s, want nil", window, got.rec.GUID)
+		}
+	}
+	rp.config.DedupeWindow = 200 * time.Hour
+	if got := rp.findEarlierNotification(db, rec, &IGDBGameInfo{ID: 9}); got != nil {
+		t.Errorf("game never sent: got %s, want nil", got.rec.GUID)
+	}
+}
+
+func TestRootInLegacyRelease(t *testing.T) {
+	// Releases recorded before the outbox only have their event IDs and room
+	db := newTestDB(t)
+	saveTestRelease(t, db, ReleaseRecord{GUID: "legacy", IGDBID: 7, Status: ReleaseStatusSent,
+		RoomID: "!a:example.org", EventIDs: []string{"$legacy"}}, time.Hour)
+
+	rp := &RSSProcessor{config: &Config{DedupeWindow: 72 * time.Hour}}
+	earlier := rp.findEarlierNotification(db, &ReleaseRecord{GUID: "new", CreatedAt: time.Now()}, &IGDBGameInfo{ID: 7})
+	if earlier == nil {
+		t.Fatal("legacy release not found")
+	}
+	if got := earlier.rootIn("!a:example.org"); got != "$legacy" {
+		t.Errorf("rootIn(feed room) = %q, want $legacy", got)
+	}
+	if got := earlier.rootIn("!b:example.org"); got != "" {
+		t.Errorf("rootIn(other room) = %q, want none", got)
+	}
+}
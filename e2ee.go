@@ -0,0 +1,88 @@
+//go:build e2ee
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"maunium.net/go/mautrix/crypto"
+	"maunium.net/go/mautrix/crypto/cryptohelper"
+)
+
+// setupEncryption enables Olm/Megolm for the client. Keys live in a crypto store next to the
+// database, tied to the session's device ID, so the device must stay the same between runs.
+func setupEncryption(mc *MatrixClient, cfg *Config) error {
+	if mc.client.DeviceID == "" {
+		return fmt.Errorf("the Matrix session has no device ID")
+	}
+	storePath := filepath.Join(filepath.Dir(dbPath), "matrix_crypto.db")
+	helper, err := cryptohelper.NewCryptoHelper(mc.client, []byte(cfg.MatrixPickleKey), storePath)
+	if err != nil {
+		return err
+	}
+	if err := helper.Init(); err != nil {
+		helper.Close()
+		return fmt.Errorf("%w (the crypto store in %s belongs to another device; log in again with that device or remove the store)", err, storePath)
+	}
+	mc.client.Crypto = helper
+
+	if err := bootstrapCrossSigning(helper.Machine(), cfg); err != nil {
+		log.Printf("Cross-signing setup failed, this device stays unverified: %v", err)
+	}
+
+	// Load the encryption state and members of our rooms now; the first sync may finish after the first notification
+	mc.PreloadRooms(configRoomIDs(cfg))
+
+	log.Printf("End-to-end encryption enabled for device %s", mc.client.DeviceID)
+	return nil
+}
+
+// bootstrapCrossSigning signs this device with the account's cross-signing keys. Keys are created
+// (with the recovery key written next to the database) when the account has none yet; otherwise
+// MATRIX_RECOVERY_KEY is needed to fetch them from secret storage.
+func bootstrapCrossSigning(mach *crypto.OlmMachine, cfg *Config) error {
+	if mach.GetOwnCrossSigningPublicKeys() == nil {
+		if cfg.MatrixPassword == "" {
+			return fmt.Errorf("MATRIX_PASSWORD is needed to create cross-signing keys")
+		}
+		recoveryKey, err := mach.GenerateAndUploadCrossSigningKeys(cfg.MatrixPassword, "")
+		if recoveryKey != "" {
+			path := filepath.Join(filepath.Dir(dbPath), "matrix_recovery_key.txt")
+			if writeErr := os.WriteFile(path, []byte(recoveryKey+"\n"), 0600); writeErr != nil {
+				log.Printf("Failed to save the cross-signing recovery key: %v", writeErr)
+			} else {
+				log.Printf("Created cross-signing keys; the recovery key is in %s", path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	} else if mach.CrossSigningKeys == nil {
+		if mach.IsDeviceTrusted(mach.OwnIdentity()) {
+			return nil
+		}
+		if cfg.MatrixRecoveryKey == "" {
+			return fmt.Errorf("the account has cross-signing keys; set MATRIX_RECOVERY_KEY to verify this device")
+		}
+		_, keyData, err := mach.SSSS.GetDefaultKeyData()
+		if err != nil {
+			return fmt.Errorf("failed to get secret storage key: %w", err)
+		}
+		key, err := keyData.VerifyRecoveryKey(cfg.MatrixRecoveryKey)
+		if err != nil {
+			return fmt.Errorf("invalid MATRIX_RECOVERY_KEY: %w", err)
+		}
+		if err := mach.FetchCrossSigningKeysFromSSSS(key); err != nil {
+			return fmt.Errorf("failed to fetch cross-signing keys: %w", err)
+		}
+	}
+
+	if err := mach.SignOwnDevice(mach.OwnIdentity()); err != nil {
+		return fmt.Errorf("failed to sign own device: %w", err)
+	}
+	log.Printf("Device %s is cross-signed", mach.OwnIdentity().DeviceID)
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !e2ee
+
+package main
+
+import "fmt"
+
+// setupEncryption fails in builds without libolm
+func setupEncryption(mc *MatrixClient, cfg *Config) error {
+	return fmt.Errorf("this binary was built without encryption support; rebuild with -tags e2ee (requires libolm)")
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FeedConfig describes a single Torznab feed and how its items are handled
+type FeedConfig struct {
+	Name       string
+	URL        string
+	APIKey     string
+	Categories []int
+	Interval   time.Duration
+	Profile    string
+	RoomID     string
+
+	// urlSetting and categoriesSetting name the settings the endpoint and categories
+	// came from, so that errors about them point at what to change
+	urlSetting        string
+	categoriesSetting string
+}
+
+// titleProfiles holds the title parsers available to feeds
+var titleProfiles = map[string]func(title string) *ParsedTitle{
+	// Scene and repack release names
+	"default": ParseReleaseTitle,
+	// Titles are already clean game names
+	"raw": func(title string) *ParsedTitle {
+		return &ParsedTitle{Raw: title, Name: strings.TrimSpace(title)}
+	},
+}
+
+// loadFeedConfigs builds the feed list from FEEDS and FEED_<NAME>_* settings.
+// Without FEEDS, one feed is created per entry in JACKETT_INDEXERS.
+func loadFeedConfigs(cfg *Config, src *configSource) ([]*FeedConfig, error) {
+	names := splitList(src.get("FEEDS", ""))
+	if len(names) == 0 {
+		names = splitList(src.get("JACKETT_INDEXERS", "all"))
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s must list at least one feed", src.name("FEEDS"))
+	}
+
+	defaultInterval, err := time.ParseDuration(src.get("POLL_INTERVAL", "1m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("POLL_INTERVAL"), err)
+	}
+
+	seen := map[string]bool{}
+	var feeds []*FeedConfig
+	for _, name := range names {
+		prefix := "FEED_" + feedEnvKey(name) + "_"
+		if seen[prefix] {
+			return nil, fmt.Errorf("feed %q is listed more than once", name)
+		}
+		seen[prefix] = true
+
+		feed := &FeedConfig{
+			Name:       name,
+			URL:        src.get(prefix+"URL", ""),
+			APIKey:     src.get(prefix+"API_KEY", cfg.JackettAPIKey),
+			Categories: cfg.TorznabCategories,
+			Interval:   defaultInterval,
+			Profile:    src.get(prefix+"PROFILE", "default"),
+			RoomID:     src.get(prefix+"ROOM", cfg.MatrixRoomID),
+
+			urlSetting:        src.name(prefix + "URL"),
+			categoriesSetting: src.name("TORZNAB_CATEGORIES"),
+		}
+
+		if feed.URL == "" {
+			if cfg.JackettURL == "" {
+				return nil, fmt.Errorf("%s or %s is required", src.name(prefix+"URL"), src.name("JACKETT_URL"))
+			}
+			feed.URL = JackettIndexerEndpoint(cfg.JackettURL, src.get(prefix+"INDEXER", name))
+			feed.urlSetting = src.name(prefix + "INDEXER")
+		}
+		if value := src.get(prefix+"CATEGORIES", ""); value != "" {
+			if feed.Categories, err = parseIntList(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", src.name(prefix+"CATEGORIES"), err)
+			}
+			feed.categoriesSetting = src.name(prefix + "CATEGORIES")
+		}
+		if value := src.get(prefix+"INTERVAL", ""); value != "" {
+			if feed.Interval, err = time.ParseDuration(value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", src.name(prefix+"INTERVAL"), err)
+			}
+		}
+		if feed.Interval < 10*time.Second {
+			return nil, fmt.Errorf("poll interval for feed %q must be at least 10s", name)
+		}
+		if _, ok := titleProfiles[feed.Profile]; !ok {
+			return nil, fmt.Errorf("%s: unknown title profile %q", src.name(prefix+"PROFILE"), feed.Profile)
+		}
+		if feed.RoomID == "" {
+			return nil, fmt.Errorf("%s or %s is required", src.name(prefix+"ROOM"), src.name("MATRIX_ROOM_ID"))
+		}
+
+		feeds = append(feeds, feed)
+	}
+
+	return feeds, nil
+}
+
+// checkFeedCaps asks the indexer of every feed for its capabilities and reports the first
+// setting it can't serve. Indexers that can't be reached are only logged, since the feed
+// loop keeps retrying them anyway.
+func (rp *RSSProcessor) checkFeedCaps(ctx context.Context, feeds []*FeedConfig) error {
+	for _, feed := range feeds {
+		capsCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		caps, err := NewTorznabClient(feed.URL, feed.APIKey, rp.client).Caps(capsCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("[%s] Could not check the indexer capabilities: %v", feed.Name, err)
+			continue
+		}
+		if err := feed.checkCaps(caps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCaps reports a setting of the feed that its indexer's capabilities rule out
+func (feed *FeedConfig) checkCaps(caps *TorznabCaps) error {
+	if !caps.SupportsSearch() {
+		return fmt.Errorf("%s: the indexer of feed %q does not support search", feed.urlSetting, feed.Name)
+	}
+	for _, id := range feed.Categories {
+		if !caps.HasCategory(id) {
+			return fmt.Errorf("%s: the indexer of feed %q has no category %d", feed.categoriesSetting, feed.Name, id)
+		}
+	}
+	return nil
+}
+
+var envKeyCleaner = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// feedEnvKey turns a feed name into the form used in FEED_<NAME>_* variables
+func feedEnvKey(name string) string {
+	return strings.ToUpper(envKeyCleaner.ReplaceAllString(name, "_"))
+}
+
+// feedLoops runs one polling loop per configured feed. Loops for feeds added by a reload are
+// started, and loops whose feed was removed stop after their current poll.
+type feedLoops struct {
+	rp      *RSSProcessor
+	db      *sql.DB
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+// runFeeds polls every feed until ctx is cancelled, following configuration reloads,
+// and returns once all feed loops have stopped
+func (rp *RSSProcessor) runFeeds(ctx context.Context, db *sql.DB) {
+	loops := &feedLoops{rp: rp, db: db, running: map[string]bool{}}
+	for {
+		reloaded := rp.configReloaded()
+		for _, feed := range rp.currentConfig().Feeds {
+			loops.start(ctx, feed.Name)
+		}
+		select {
+		case <-ctx.Done():
+			loops.wg.Wait()
+			return
+		case <-reloaded:
+		}
+	}
+}
+
+// start runs the loop of a feed unless it is already running
+func (fl *feedLoops) start(ctx context.Context, name string) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.running[name] {
+		return
+	}
+	fl.running[name] = true
+	fl.wg.Add(1)
+	go func() {
+		defer fl.wg.Done()
+		fl.run(ctx, name)
+	}()
+}
+
+// feed returns the current settings of a feed. When the feed is no longer configured
+// it returns nil and marks the loop as stopped, so a later reload can start it again.
+func (fl *feedLoops) feed(name string) *FeedConfig {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	for _, feed := range fl.rp.currentConfig().Feeds {
+		if feed.Name == name {
+			return feed
+		}
+	}
+	delete(fl.running, name)
+	return nil
+}
+
+// run polls a single feed on its own interval until ctx is cancelled or the feed is removed.
+// Errors are logged so that one broken feed does not stop the others.
+func (fl *feedLoops) run(ctx context.Context, name string) {
+	feed := fl.feed(name)
+	if feed == nil {
+		return
+	}
+	log.Printf("[%s] Polling %s every %s", feed.Name, feed.URL, feed.Interval)
+	for {
+		started := time.Now()
+		err := fl.rp.processFeed(ctx, fl.db, feed)
+		switch {
+		case ctx.Err() != nil:
+			log.Printf("[%s] Stopped polling", name)
+			return
+		case err != nil:
+			log.Printf("[%s] Failed to process feed: %v", name, err)
+		default:
+			log.Printf("[%s] Feed processing completed successfully!", name)
+		}
+
+		// Wait for the next poll; a reload may change the interval or remove the feed
+		for {
+			reloaded := fl.rp.configReloaded()
+			timer := time.NewTimer(time.Until(started.Add(feed.Interval)))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				log.Printf("[%s] Stopped polling", name)
+				return
+			case <-reloaded:
+				timer.Stop()
+				if feed = fl.feed(name); feed == nil {
+					log.Printf("[%s] Feed removed from the configuration, stopped polling", name)
+					return
+				}
+				continue
+			case <-timer.C:
+			}
+			break
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with ctx's error when ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
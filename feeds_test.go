@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFeedEnvKey(t *testing.T) {
+	cases := map[string]string{
+		"zamunda":        "ZAMUNDA",
+		"arena-bg":       "ARENA_BG",
+		"Games & Apps!!": "GAMES_APPS_",
+		"1337x.to":       "1337X_TO",
+	}
+	for name, want := range cases {
+		if got := feedEnvKey(name); got != want {
+			t.Errorf("feedEnvKey(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadFeedConfigs(t *testing.T) {
+	cfg := &Config{
+		JackettURL:        "http://jackett:9117",
+		JackettAPIKey:     "global-key",
+		TorznabCategories: []int{4000},
+		MatrixRoomID:      "!default:example.org",
+	}
+	src := &configSource{dotenv: map[string]string{
+		"FEEDS":                    "zamunda, arena-bg",
+		"POLL_INTERVAL":            "2m",
+		"FEED_ARENA_BG_URL":        "https://arena.example/api",
+		"FEED_ARENA_BG_API_KEY":    "arena-key",
+		"FEED_ARENA_BG_CATEGORIES": "4050,1000",
+		"FEED_ARENA_BG_INTERVAL":   "30s",
+		"FEED_ARENA_BG_PROFILE":    "raw",
+		"FEED_ARENA_BG_ROOM":       "!arena:example.org",
+	}}
+
+	feeds, err := loadFeedConfigs(cfg, src)
+	if err != nil {
+		t.Fatalf("loadFeedConfigs: %v", err)
+	}
+	if len(feeds) != 2 {
+		t.Fatalf("got %d feeds, want 2", len(feeds))
+	}
+
+	zamunda := feeds[0]
+	if zamunda.URL != "http://jackett:9117/api/v2.0/indexers/zamunda/results/torznab/api" {
+		t.Errorf("zamunda URL = %q", zamunda.URL)
+	}
+	if zamunda.APIKey != "global-key" || zamunda.Interval != 2*time.Minute || zamunda.Profile != "default" || zamunda.RoomID != "!default:example.org" {
+		t.Errorf("zamunda did not inherit the global settings: %+v", zamunda)
+	}
+
+	arena := feeds[1]
+	if arena.URL != "https://arena.example/api" || arena.APIKey != "arena-key" {
+		t.Errorf("arena endpoint = %q/%q", arena.URL, arena.APIKey)
+	}
+	if len(arena.Categories) != 2 || arena.Categories[0] != 4050 {
+		t.Errorf("arena categories = %v", arena.Categories)
+	}
+	if arena.Interval != 30*time.Second || arena.Profile != "raw" || arena.RoomID != "!arena:example.org" {
+		t.Errorf("arena overrides not applied: %+v", arena)
+	}
+}
+
+func TestLoadFeedConfigsErrors(t *testing.T) {
+	cfg := &Config{JackettURL: "http://jackett:9117", MatrixRoomID: "!room:example.org"}
+	cases := []struct {
+		name string
+		vars map[string]string
+		want string
+	}{
+		{"duplicate", map[string]string{"FEEDS": "a-b,a_b"}, "listed more than once"},
+		{"short interval", map[string]string{"FEEDS": "a", "FEED_A_INTERVAL": "5s"}, "at least 10s"},
+		{"bad interval", map[string]string{"FEEDS": "a", "FEED_A_INTERVAL": "soon"}, "FEED_A_INTERVAL"},
+		{"bad categories", map[string]string{"FEEDS": "a", "FEED_A_CATEGORIES": "pc"}, "FEED_A_CATEGORIES"},
+		{"unknown profile", map[string]string{"FEEDS": "a", "FEED_A_PROFILE": "fancy"}, "unknown title profile"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadFeedConfigs(cfg, &configSource{dotenv: tc.vars})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("got error %v, want one mentioning %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckFeedCaps(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caps := testTorznabCaps
+		if strings.Contains(r.URL.Path, "/nosearch/") {
+			caps = strings.Replace(caps, `<search available="yes"`, `<search available="no"`, 1)
+		}
+		w.Write([]byte(caps))
+	}))
+	defer srv.Close()
+	rp := &RSSProcessor{client: srv.Client()}
+	cfg := &Config{JackettURL: srv.URL, TorznabCategories: []int{4000}, MatrixRoomID: "!room:example.org"}
+
+	cases := []struct {
+		name string
+		vars map[string]string
+		want string
+	}{
+		{"supported", map[string]string{"FEEDS": "a", "FEED_A_CATEGORIES": "4050,1000"}, ""},
+		{"unreachable", map[string]string{"FEEDS": "a", "FEED_A_URL": "http://127.0.0.1:1/api"}, ""},
+		{"no search", map[string]string{"FEEDS": "a", "FEED_A_INDEXER": "nosearch"}, "FEED_A_INDEXER"},
+		{"unknown category", map[string]string{"FEEDS": "a,b", "FEED_B_CATEGORIES": "4050,5000"}, "FEED_B_CATEGORIES: the indexer of feed \"b\" has no category 5000"},
+		{"unknown default category", map[string]string{"FEEDS": "a", "TORZNAB_CATEGORIES": "3000"}, "TORZNAB_CATEGORIES"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := *cfg
+			if value := tc.vars["TORZNAB_CATEGORIES"]; value != "" {
+				cfg.TorznabCategories, _ = parseIntList(value)
+			}
+			feeds, err := loadFeedConfigs(&cfg, &configSource{dotenv: tc.vars})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = rp.checkFeedCaps(context.Background(), feeds)
+			switch {
+			case tc.want == "" && err != nil:
+				t.Errorf("checkFeedCaps: %v", err)
+			case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+				t.Errorf("got error %v, want one mentioning %q", err, tc.want)
+			}
+		})
+	}
+}
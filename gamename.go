@@ -0,0 +1,291 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ParsedTitle is the structured result of parsing a torrent release title
+type ParsedTitle struct {
+	Raw       string
+	Name      string
+	Version   string
+	Build     string
+	Group     string
+	Repack    bool
+	Edition   string
+	DLCs      []string
+	Languages []string
+}
+
+// knownGroups maps lower-cased release group names to their canonical spelling
+var knownGroups = map[string]string{
+	"rune": "RUNE", "tenoke": "TENOKE", "flt": "FLT", "fairlight": "FAiRLiGHT", "codex": "CODEX",
+	"skidrow": "SKIDROW", "plaza": "PLAZA", "cpy": "CPY", "empress": "EMPRESS", "doge": "DOGE",
+	"tinyiso": "TiNYiSO", "razor1911": "Razor1911", "hoodlum": "HOODLUM", "darksiders": "DARKSiDERS",
+	"reloaded": "RELOADED", "prophet": "PROPHET", "simplex": "SiMPLEX", "steampunks": "STEAMPUNKS",
+	"goldberg": "Goldberg", "ali213": "ALI213", "3dm": "3DM", "p2p": "P2P", "gog": "GOG",
+	"fitgirl": "FitGirl", "dodi": "DODI", "elamigos": "ElAmigos", "kaos": "KaOs", "xatab": "xatab",
+	"chovka": "Chovka", "decepticon": "Decepticon", "spieler": "Spieler", "insaneramzes": "InsaneRamZes",
+}
+
+// repackGroups are groups that only publish repacks
+var repackGroups = map[string]bool{
+	"FitGirl": true, "DODI": true, "ElAmigos": true, "KaOs": true, "xatab": true,
+	"Chovka": true, "Decepticon": true, "Spieler": true, "InsaneRamZes": true,
+}
+
+// noiseWords end the game name and carry no information of their own
+var noiseWords = map[string]bool{
+	"pc": true, "win": true, "win32": true, "win64": true, "x86": true, "x64": true, "windows": true,
+	"linux": true, "macos": true, "mac": true, "portable": true, "proper": true, "internal": true,
+	"readnfo": true, "crack": true, "cracked": true, "crackfix": true, "iso": true, "rip": true,
+	"steam": true, "steamrip": true, "multi": true, "update": true, "hotfix": true, "patch": true,
+	"incl": true, "incl.": true, "including": true, "dlc": true, "dlcs": true, "bonus": true,
+	"early": true, "access": true,
+}
+
+var (
+	titleTagPattern      = regexp.MustCompile(`[\[\(\{]([^\[\]\(\)\{\}]*)[\]\)\}]`)
+	titleGroupPattern    = regexp.MustCompile(`^(.*[^\s-])-([A-Za-z0-9_]+)$`)
+	titleSceneDots       = regexp.MustCompile(`([^0-9])\.|\.([^0-9])`)
+	titleVersionPattern  = regexp.MustCompile(`(?i)^v\d+(\.\d+)*([a-z]\d*)?$|^\d+(\.\d+){2,}([a-z]\d*)?$|^\d+\.\d+[a-z]?$`)
+	titleBuildPattern    = regexp.MustCompile(`(?i)^(build|b)[.\s]?(\d{3,})$`)
+	titleLanguagePattern = regexp.MustCompile(`(?i)^(multi|ml)\d*$`)
+	titleLanguageCodes   = regexp.MustCompile(`^(RUS|ENG|GER|FRE|FRA|SPA|ITA|POL|JPN|JAP|BUL|UKR|CHS|CHT|KOR|POR|BRA|TUR|CZE|HUN)$`)
+	titleEditionPattern  = regexp.MustCompile(`(?i)\s+((digital\s+)?(deluxe|ultimate|gold|premium|complete|definitive|collector'?s|anniversary|special|standard|legendary|game\s+of\s+the\s+year|goty)(\s+edition)?|director'?s\s+cut|(digital\s+)?[^\s\-–]+\s+edition)$`)
+	titleDLCCount        = regexp.MustCompile(`(?i)^\+?\s*(\d+|all)\s+dlcs?$`)
+	titleDLCSuffix       = regexp.MustCompile(`(?i)\s*\bdlcs?$`)
+	titleYearPattern     = regexp.MustCompile(`^(19|20)\d{2}$`)
+)
+
+// ParseReleaseTitle extracts the game name and release details from a torrent title. It understands
+// dotted/underscored scene names (Game.Name.v1.2.3-RUNE), bracketed tags ([FitGirl Repack], (MULTi12)),
+// versions and builds, language tags, edition words and "+ DLC" suffixes.
+func ParseReleaseTitle(title string) *ParsedTitle {
+	pt := &ParsedTitle{Raw: title}
+	region := strings.TrimSpace(title)
+
+	// Bracketed tags: analyze them, and cut the name at the first tag that follows some text
+	cut := -1
+	for _, loc := range titleTagPattern.FindAllStringSubmatchIndex(region, -1) {
+		pt.parseTag(region[loc[2]:loc[3]])
+		if cut < 0 && strings.TrimSpace(region[:loc[0]]) != "" {
+			cut = loc[0]
+		}
+	}
+	if cut >= 0 {
+		region = region[:cut]
+	}
+	region = strings.TrimSpace(titleTagPattern.ReplaceAllString(region, " "))
+
+	// Scene group suffix: Game.Name.v1.0-GROUP, or Game Name-FitGirl for known groups
+	if m := titleGroupPattern.FindStringSubmatch(region); m != nil {
+		canonical, known := knownGroups[strings.ToLower(m[2])]
+		if known || strings.ContainsAny(m[1], "._") {
+			if !known {
+				canonical = m[2]
+			}
+			pt.setGroup(canonical)
+			region = m[1]
+		}
+	}
+
+	// Dotted and underscored names use separators instead of spaces; keep dots inside versions
+	region = strings.ReplaceAll(region, "_", " ")
+	if !strings.Contains(region, " ") {
+		for titleSceneDots.MatchString(region) {
+			region = titleSceneDots.ReplaceAllString(region, "$1 $2")
+		}
+	}
+
+	var nameTokens []string
+	stopped := false
+	dashAt := -1 // index in nameTokens of the first " - " separator
+	tokens := strings.Fields(region)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		lower := strings.ToLower(tok)
+
+		switch {
+		case strings.HasPrefix(tok, "+"):
+			// Everything after a "+" lists DLCs or extras
+			pt.parseDLCs(strings.Join(tokens[i:], " "))
+			i = len(tokens)
+			stopped = true
+		case titleVersionPattern.MatchString(tok) && (len(nameTokens) > 0 || stopped):
+			if pt.Version == "" {
+				pt.Version = strings.TrimPrefix(lower, "v")
+			}
+			stopped = true
+		case titleBuildPattern.MatchString(tok):
+			pt.Build = titleBuildPattern.FindStringSubmatch(tok)[2]
+			stopped = true
+		case lower == "build" && i+1 < len(tokens) && isDigits(tokens[i+1]):
+			pt.Build = tokens[i+1]
+			i++
+			stopped = true
+		case lower == "repack" || lower == "repacked":
+			pt.Repack = true
+			stopped = true
+		case titleLanguagePattern.MatchString(tok) || titleLanguageCodes.MatchString(tok):
+			pt.addLanguage(tok)
+			stopped = true
+		case lower == "-" || lower == "–":
+			// "Game - Premium Edition" splits off the edition; "Game - Subtitle" keeps the subtitle in the name
+			if stopped || dashAt >= 0 || len(nameTokens) == 0 {
+				stopped = true
+			} else {
+				dashAt = len(nameTokens)
+			}
+		case noiseWords[lower]:
+			stopped = true
+		case knownGroups[lower] != "" && len(nameTokens) > 0 && (i == len(tokens)-1 || strings.EqualFold(tokens[i+1], "repack")):
+			pt.setGroup(knownGroups[lower])
+			stopped = true
+		case !stopped:
+			nameTokens = append(nameTokens, tok)
+		}
+	}
+
+	if dashAt >= 0 {
+		// An edition after the dash is split off; a subtitle stays part of the name
+		rest := strings.Join(nameTokens[dashAt:], " ")
+		if m := titleEditionPattern.FindStringIndex(" " + rest); m != nil && m[0] == 0 {
+			nameTokens = nameTokens[:dashAt]
+			pt.Edition = rest
+		}
+	}
+
+	name := strings.Join(nameTokens, " ")
+	for {
+		m := titleEditionPattern.FindStringSubmatchIndex(name)
+		if m == nil || m[0] == 0 {
+			break
+		}
+		edition := strings.TrimSpace(name[m[0]:])
+		if pt.Edition == "" {
+			pt.Edition = edition
+		} else {
+			pt.Edition = edition + " " + pt.Edition
+		}
+		name = name[:m[0]]
+	}
+	name = strings.Trim(name, " -–:,.")
+	if name == "" {
+		name = strings.TrimSpace(title)
+	}
+	pt.Name = name
+
+	return pt
+}
+
+// parseTag extracts release details from the contents of a bracketed tag
+func (pt *ParsedTitle) parseTag(tag string) {
+	tag = strings.TrimSpace(tag)
+	if tag == "" {
+		return
+	}
+	lower := strings.ToLower(tag)
+	if strings.Contains(lower, "dlc") {
+		pt.parseDLCs(tag)
+	}
+
+	words := strings.FieldsFunc(tag, func(r rune) bool {
+		return r == ' ' || r == '/' || r == ',' || r == '|' || r == '+' || r == '-' || r == '_'
+	})
+	var other []string
+	for i, word := range words {
+		wordLower := strings.ToLower(word)
+		switch {
+		case wordLower == "repack" || wordLower == "repacked":
+			pt.Repack = true
+		case knownGroups[wordLower] != "":
+			pt.setGroup(knownGroups[wordLower])
+		case titleVersionPattern.MatchString(word):
+			if pt.Version == "" {
+				pt.Version = strings.TrimPrefix(wordLower, "v")
+			}
+		case titleBuildPattern.MatchString(word):
+			pt.Build = titleBuildPattern.FindStringSubmatch(word)[2]
+		case wordLower == "build" && i+1 < len(words) && isDigits(words[i+1]):
+			pt.Build = words[i+1]
+		case titleLanguagePattern.MatchString(word) || titleLanguageCodes.MatchString(strings.ToUpper(word)) && len(word) == 3 && word == strings.ToUpper(word):
+			pt.addLanguage(word)
+		case len(word) == 2 && word == strings.ToUpper(word) && isLanguageCode2(word):
+			pt.addLanguage(word)
+		case wordLower == "by" || titleYearPattern.MatchString(word) || isDigits(word):
+		default:
+			other = append(other, word)
+		}
+	}
+
+	// "(Repack by Someone)" names an unknown repacker
+	if strings.Contains(lower, "repack") && pt.Group == "" && len(other) == 1 && !noiseWords[strings.ToLower(other[0])] {
+		pt.setGroup(other[0])
+	}
+}
+
+// parseDLCs records DLCs from text such as "+ 5 DLCs", "+ Bonus Content" or "Incl. All DLCs"
+func (pt *ParsedTitle) parseDLCs(text string) {
+	for _, part := range strings.FieldsFunc(text, func(r rune) bool { return r == '+' || r == ',' }) {
+		part = strings.TrimSpace(part)
+		lower := strings.ToLower(part)
+		lower = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(lower, "incl."), "incl"))
+		if part == "" {
+			continue
+		}
+		if m := titleDLCCount.FindStringSubmatch(lower); m != nil {
+			pt.DLCs = append(pt.DLCs, m[1]+" DLCs")
+			continue
+		}
+		if titleVersionPattern.MatchString(part) || titleLanguagePattern.MatchString(part) || lower == "dlc" || lower == "dlcs" || lower == "all dlcs" {
+			continue
+		}
+		part = strings.TrimSpace(titleDLCSuffix.ReplaceAllString(part, ""))
+		if part != "" {
+			pt.DLCs = append(pt.DLCs, part)
+		}
+	}
+}
+
+// setGroup records the release group, implying a repack for repack-only groups
+func (pt *ParsedTitle) setGroup(group string) {
+	if pt.Group == "" {
+		pt.Group = group
+	}
+	if repackGroups[group] {
+		pt.Repack = true
+	}
+}
+
+// addLanguage records a language tag once
+func (pt *ParsedTitle) addLanguage(lang string) {
+	for _, existing := range pt.Languages {
+		if strings.EqualFold(existing, lang) {
+			return
+		}
+	}
+	pt.Languages = append(pt.Languages, lang)
+}
+
+// isLanguageCode2 reports whether s is a two-letter language code commonly used in release tags
+func isLanguageCode2(s string) bool {
+	switch s {
+	case "EN", "RU", "DE", "FR", "ES", "IT", "PL", "JP", "BG", "UA", "CN", "KR", "PT", "BR", "TR", "CZ":
+		return true
+	}
+	return false
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseReleaseTitle(t *testing.T) {
+	cases := []struct {
+		title string
+		want  ParsedTitle
+	}{
+		// Scene groups
+		{"Starfield-RUNE", ParsedTitle{Name: "Starfield", Group: "RUNE"}},
+		{"Baldurs.Gate.3.v4.1.1.3624901-RUNE", ParsedTitle{Name: "Baldurs Gate 3", Version: "4.1.1.3624901", Group: "RUNE"}},
+		{"Lies.of.P-FLT", ParsedTitle{Name: "Lies of P", Group: "FLT"}},
+		{"Hades.II.Early.Access-TENOKE", ParsedTitle{Name: "Hades II", Group: "TENOKE"}},
+		{"Sea_of_Stars-TENOKE", ParsedTitle{Name: "Sea of Stars", Group: "TENOKE"}},
+		{"Cities.Skylines.II.Ultimate.Edition-RUNE", ParsedTitle{Name: "Cities Skylines II", Edition: "Ultimate Edition", Group: "RUNE"}},
+		{"Alan.Wake.2.Update.v1.0.8-RUNE", ParsedTitle{Name: "Alan Wake 2", Version: "1.0.8", Group: "RUNE"}},
+		{"Dredge.The.Iron.Rig.PROPER-TENOKE", ParsedTitle{Name: "Dredge The Iron Rig", Group: "TENOKE"}},
+		{"Warhammer.40000.Rogue.Trader.MULTi12-FLT", ParsedTitle{Name: "Warhammer 40000 Rogue Trader", Group: "FLT", Languages: []string{"MULTi12"}}},
+		{"Some.Indie.Game-UNKNOWNGRP", ParsedTitle{Name: "Some Indie Game", Group: "UNKNOWNGRP"}},
+
+		// Repacks
+		{"Starfield - Premium Edition [FitGirl Repack]", ParsedTitle{Name: "Starfield", Edition: "Premium Edition", Group: "FitGirl", Repack: true}},
+		{"Cyberpunk 2077: Ultimate Edition (v2.12 + All DLCs + Bonus Content, MULTi19) [FitGirl Repack]", ParsedTitle{Name: "Cyberpunk 2077", Edition: "Ultimate Edition", Version: "2.12", Group: "FitGirl", Repack: true, DLCs: []string{"all DLCs", "Bonus Content"}, Languages: []string{"MULTi19"}}},
+		{"Elden Ring [DODI Repack]", ParsedTitle{Name: "Elden Ring", Group: "DODI", Repack: true}},
+		{"Hogwarts Legacy - Digital Deluxe Edition v1.0 + 3 DLCs [DODI Repack]", ParsedTitle{Name: "Hogwarts Legacy", Edition: "Digital Deluxe Edition", Version: "1.0", Group: "DODI", Repack: true, DLCs: []string{"3 DLCs"}}},
+		{"Red Dead Redemption 2 [ElAmigos]", ParsedTitle{Name: "Red Dead Redemption 2", Group: "ElAmigos", Repack: true}},
+		{"Atomic Heart-ElAmigos", ParsedTitle{Name: "Atomic Heart", Group: "ElAmigos", Repack: true}},
+		{"Dead Island 2 (Repack by Chovka)", ParsedTitle{Name: "Dead Island 2", Group: "Chovka", Repack: true}},
+		{"Palworld (Repack by Xyzzy)", ParsedTitle{Name: "Palworld", Group: "Xyzzy", Repack: true}},
+		{"The Witcher 3 Wild Hunt GOTY Repack", ParsedTitle{Name: "The Witcher 3 Wild Hunt", Edition: "GOTY", Repack: true}},
+
+		// GOG and builds
+		{"Stardew Valley v1.6.8 [GOG]", ParsedTitle{Name: "Stardew Valley", Version: "1.6.8", Group: "GOG"}},
+		{"Frostpunk 2 (Build 15640123) [GOG]", ParsedTitle{Name: "Frostpunk 2", Build: "15640123", Group: "GOG"}},
+		{"Factorio Build 123456-GOG", ParsedTitle{Name: "Factorio", Build: "123456", Group: "GOG"}},
+		{"Valheim.Build.14356123-P2P", ParsedTitle{Name: "Valheim", Build: "14356123", Group: "P2P"}},
+		{"Disco Elysium - The Final Cut v1.0.2b-GOG", ParsedTitle{Name: "Disco Elysium The Final Cut", Version: "1.0.2b", Group: "GOG"}},
+
+		// Editions
+		{"F1 24 Champions Edition", ParsedTitle{Name: "F1 24", Edition: "Champions Edition"}},
+		{"Grand Theft Auto V Enhanced", ParsedTitle{Name: "Grand Theft Auto V Enhanced"}},
+		{"Grand Theft Auto V Enhanced Edition", ParsedTitle{Name: "Grand Theft Auto V", Edition: "Enhanced Edition"}},
+		{"Death Stranding Director's Cut-RUNE", ParsedTitle{Name: "Death Stranding", Edition: "Director's Cut", Group: "RUNE"}},
+		{"Mass Effect Legendary Edition", ParsedTitle{Name: "Mass Effect", Edition: "Legendary Edition"}},
+		{"Borderlands 3 Game of the Year Edition", ParsedTitle{Name: "Borderlands 3", Edition: "Game of the Year Edition"}},
+
+		// Subtitles after a dash are part of the name
+		{"Batman - Arkham Knight-CODEX", ParsedTitle{Name: "Batman Arkham Knight", Group: "CODEX"}},
+		{"Star Wars - Knights of the Old Republic-RUNE", ParsedTitle{Name: "Star Wars Knights of the Old Republic", Group: "RUNE"}},
+		{"Disco Elysium - The Final Cut-GOG", ParsedTitle{Name: "Disco Elysium The Final Cut", Group: "GOG"}},
+		{"Warhammer 40,000 - Space Marine 2 Gold Edition-RUNE", ParsedTitle{Name: "Warhammer 40,000 Space Marine 2", Edition: "Gold Edition", Group: "RUNE"}},
+
+		// Languages
+		{"Atomfall [MULTi9] [FitGirl Repack]", ParsedTitle{Name: "Atomfall", Group: "FitGirl", Repack: true, Languages: []string{"MULTi9"}}},
+		{"Metro Exodus (RUS/ENG)", ParsedTitle{Name: "Metro Exodus", Languages: []string{"RUS", "ENG"}}},
+		{"Kingdom Come Deliverance II (EN, DE, RU)", ParsedTitle{Name: "Kingdom Come Deliverance II", Languages: []string{"EN", "DE", "RU"}}},
+
+		// DLCs
+		{"Stellaris v3.12 + 38 DLCs", ParsedTitle{Name: "Stellaris", Version: "3.12", DLCs: []string{"38 DLCs"}}},
+		{"Crusader Kings III + Royal Court DLC", ParsedTitle{Name: "Crusader Kings III", DLCs: []string{"Royal Court"}}},
+		{"Civilization VI (Incl. All DLCs) [GOG]", ParsedTitle{Name: "Civilization VI", Group: "GOG", DLCs: []string{"all DLCs"}}},
+
+		// Plain names and names that look like release details
+		{"Portal 2", ParsedTitle{Name: "Portal 2"}},
+		{"1.0", ParsedTitle{Name: "1.0"}},
+		{"2064 Read Only Memories", ParsedTitle{Name: "2064 Read Only Memories"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.title, func(t *testing.T) {
+			got := ParseReleaseTitle(tc.title)
+			want := tc.want
+			want.Raw = tc.title
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("ParseReleaseTitle(%q)\n got  %+v\n want %+v", tc.title, *got, want)
+			}
+		})
+	}
+}
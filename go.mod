@@ -3,26 +3,22 @@ module zamunda-rss-jackett
 go 1.21
 
 require (
+	github.com/Henry-Sarabia/igdb/v2 v2.0.0-alpha.4
+	github.com/buckket/go-blurhash v1.1.0
+	github.com/disintegration/imaging v1.6.2
 	github.com/joho/godotenv v1.5.1
-	github.com/mmcdole/gofeed v1.2.1
+	github.com/mattn/go-sqlite3 v1.14.17
+	golang.org/x/image v0.0.0-20211028202545-6944b10bf410
+	gopkg.in/yaml.v3 v3.0.1
 	maunium.net/go/mautrix v0.15.4
 )
 
 require (
 	github.com/Henry-Sarabia/apicalypse v1.0.2 // indirect
 	github.com/Henry-Sarabia/blank v3.0.0+incompatible // indirect
-	github.com/Henry-Sarabia/igdb/v2 v2.0.0-alpha.4 // indirect
 	github.com/Henry-Sarabia/sliceconv v1.0.2 // indirect
-	github.com/PuerkitoBio/goquery v1.8.1 // indirect
-	github.com/andybalholm/cascadia v1.3.2 // indirect
-	github.com/buckket/go-blurhash v1.1.0 // indirect
-	github.com/disintegration/imaging v1.6.2 // indirect
-	github.com/json-iterator/go v1.1.12 // indirect
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
-	github.com/mmcdole/goxpp v1.1.0 // indirect
-	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rs/zerolog v1.29.1 // indirect
 	github.com/tidwall/gjson v1.17.0 // indirect
@@ -31,7 +27,6 @@ require (
 	github.com/tidwall/sjson v1.2.5 // indirect
 	golang.org/x/crypto v0.14.0 // indirect
 	golang.org/x/exp v0.0.0-20230713183714-613f0c0eb8a1 // indirect
-	golang.org/x/image v0.0.0-20211028202545-6944b10bf410 // indirect
 	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"sort"
@@ -15,13 +17,33 @@ import (
 
 // IGDBGameInfo holds the info we want from IGDB
 type IGDBGameInfo struct {
-	Title       string
-	Date        int64
-	Summary     string
-	Storyline   string
-	IGDBURL     string
-	CoverURL    string
-	Screenshots []string
+	ID               int
+	Title            string
+	Date             int64
+	Summary          string
+	Storyline        string
+	IGDBURL          string
+	CoverURL         string
+	Screenshots      []string
+	Rating           float64 // user rating, 0-100
+	AggregatedRating float64 // critic rating, 0-100
+	TotalRating      float64 // combined user and critic rating, 0-100
+	Genres           []string
+	Platforms        []string
+	GameModes        []string
+	Themes           []string
+	Developers       []string
+	Publishers       []string
+	Franchise        string
+	MatchScore       float64 // score of this game against the search query
+}
+
+// OverallRating returns the combined user and critic rating, or the user rating when there is none
+func (g *IGDBGameInfo) OverallRating() float64 {
+	if g.TotalRating > 0 {
+		return g.TotalRating
+	}
+	return g.Rating
 }
 
 // GameInfo represents game information from IGDB (for compatibility)
@@ -34,68 +56,47 @@ type GameInfo struct {
 	Platforms   []string
 }
 
-// IGDBAuthTransport handles OAuth2 authentication for IGDB
-type IGDBAuthTransport struct {
-	Token     string
-	ClientID  string
-	Transport http.RoundTripper
-}
-
-func (t *IGDBAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Set("Authorization", "Bearer "+t.Token)
-	req.Header.Set("Client-ID", t.ClientID)
-	return t.Transport.RoundTrip(req)
-}
+// igdbAPIURL is the base URL for raw IGDB API queries
+const igdbAPIURL = "https://api.igdb.com/v4/"
 
 // IGDBClient handles IGDB API operations
 type IGDBClient struct {
-	client *igdb.Client
+	httpClient *http.Client
+	cache      *IGDBCache
+	scorer     MatchScorer
 }
 
-// NewIGDBClient creates a new IGDB client
-func NewIGDBClient(clientID, clientSecret string) (*IGDBClient, error) {
-	token, err := getIGDBAccessToken(clientID, clientSecret)
-	if err != nil {
+// NewIGDBClient creates a new IGDB client; a nil cache disables lookup caching, and access
+// tokens are kept in creds when it is not nil
+func NewIGDBClient(clientID, clientSecret string, cache *IGDBCache, creds *CredentialStore) (*IGDBClient, error) {
+	tokenSource := NewIGDBTokenSource(clientID, clientSecret, creds)
+
+	// Fetch the first token up front so bad credentials fail at startup
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := tokenSource.Token(ctx); err != nil {
 		return nil, err
 	}
 
+	// IGDB allows 4 requests per second and 8 open requests; all IGDB traffic shares this limiter
 	httpClient := &http.Client{
 		Transport: &IGDBAuthTransport{
-			Token:     token,
+			Source:    tokenSource,
 			ClientID:  clientID,
-			Transport: http.DefaultTransport,
+			Transport: NewRateLimitedTransport(http.DefaultTransport, 4, 8, 3),
 		},
 	}
 
-	client := igdb.NewClient(clientID, "", httpClient)
-
 	return &IGDBClient{
-		client: client,
+		httpClient: httpClient,
+		cache:      cache,
+		scorer:     defaultMatchScorer{},
 	}, nil
 }
 
-// getIGDBAccessToken retrieves an access token from Twitch OAuth2
-func getIGDBAccessToken(clientID, clientSecret string) (string, error) {
-	url := "https://id.twitch.tv/oauth2/token"
-	data := fmt.Sprintf("client_id=%s&client_secret=%s&grant_type=client_credentials", clientID, clientSecret)
-	resp, err := http.Post(url, "application/x-www-form-urlencoded", strings.NewReader(data))
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var res struct {
-		AccessToken string `json:"access_token"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return "", err
-	}
-	return res.AccessToken, nil
-}
-
 // SearchGame searches for a game by name and returns game information
-func (ic *IGDBClient) SearchGame(gameName string) (*GameInfo, error) {
-	igdbInfo, err := ic.SearchGameWithImages(gameName)
+func (ic *IGDBClient) SearchGame(ctx context.Context, gameName string) (*GameInfo, error) {
+	igdbInfo, err := ic.SearchGameWithImages(ctx, gameName)
 	if err != nil {
 		return nil, err
 	}
@@ -104,33 +105,117 @@ func (ic *IGDBClient) SearchGame(gameName string) (*GameInfo, error) {
 	gameInfo := &GameInfo{
 		Name:        igdbInfo.Title,
 		Summary:     igdbInfo.Summary,
-		Rating:      0, // We'll need to get this from the original game data
+		Rating:      igdbInfo.Rating,
 		ReleaseDate: formatReleaseDate(igdbInfo.Date),
-		Genres:      []string{}, // We'll need to get this from the original game data
-		Platforms:   []string{}, // We'll need to get this from the original game data
+		Genres:      igdbInfo.Genres,
+		Platforms:   igdbInfo.Platforms,
 	}
 
 	return gameInfo, nil
 }
 
-// SearchGameWithImages searches for a game by name and returns full IGDB information including images
-func (ic *IGDBClient) SearchGameWithImages(gameName string) (*IGDBGameInfo, error) {
+// SearchGameWithImages searches for a game by name and returns full IGDB information including images.
+// Results, including misses, are served from and stored in the lookup cache when one is configured.
+func (ic *IGDBClient) SearchGameWithImages(ctx context.Context, gameName string) (*IGDBGameInfo, error) {
+	if ic.cache == nil {
+		info, _, err := ic.searchGameWithImages(ctx, gameName)
+		return info, err
+	}
+
+	// A match pinned with !rematch wins over the cache and the search
+	if gameID, err := ic.cache.LookupPin(gameName); err != nil {
+		log.Printf("IGDB pin lookup failed for '%s': %v", gameName, err)
+	} else if gameID != 0 {
+		log.Printf("IGDB match for '%s' is pinned to game %d", gameName, gameID)
+		return ic.GetGameByID(ctx, gameID)
+	}
+
+	info, found, err := ic.cache.Lookup(gameName)
+	if err != nil {
+		log.Printf("IGDB cache lookup failed for '%s': %v", gameName, err)
+	} else if found {
+		if info == nil {
+			log.Printf("IGDB cache hit (no match) for '%s'", gameName)
+			return nil, fmt.Errorf("no games found for '%s' (cached): %w", gameName, ErrNoIGDBMatch)
+		}
+		log.Printf("IGDB cache hit for '%s': %s", gameName, info.Title)
+		return info, nil
+	}
+
+	info, complete, err := ic.searchGameWithImages(ctx, gameName)
+	if err != nil {
+		if errors.Is(err, ErrNoIGDBMatch) {
+			if cacheErr := ic.cache.StoreNegative(gameName); cacheErr != nil {
+				log.Printf("Failed to cache IGDB miss for '%s': %v", gameName, cacheErr)
+			}
+		}
+		return nil, err
+	}
+	// A match without its details is still shown, but looked up again next time
+	if !complete {
+		return info, nil
+	}
+	if cacheErr := ic.cache.Store(gameName, info); cacheErr != nil {
+		log.Printf("Failed to cache IGDB result for '%s': %v", gameName, cacheErr)
+	}
+	return info, nil
+}
+
+// searchGameWithImages performs the uncached IGDB lookup for SearchGameWithImages. complete is
+// false when the game was matched but its details could not be fetched.
+func (ic *IGDBClient) searchGameWithImages(ctx context.Context, gameName string) (info *IGDBGameInfo, complete bool, err error) {
 	// Add context with timeout for API calls
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	// Search for the game with a higher limit to get multiple results
-	// Use Search method and then sort the results by date in our code
-	games, err := ic.client.Games.Search(gameName,
-		igdb.SetFields("name,first_release_date,summary,storyline,slug,cover,screenshots,rating,genres,platforms,category,status"),
-		igdb.SetLimit(50), // Get more results to have better selection
-		igdb.SetFilter("first_release_date", igdb.OpGreaterThan, fmt.Sprintf("%d", time.Now().AddDate(-20, 0, 0).Unix())), // Only games from last 20 years
-	)
+	// Find the best matching game using the scorer, keeping the ranking for later inspection
+	candidates, err := ic.MatchCandidates(ctx, gameName)
 	if err != nil {
+		return nil, false, err
+	}
+	logMatchCandidates(gameName, candidates)
+	if ic.cache != nil {
+		if err := ic.cache.StoreCandidates(gameName, candidates); err != nil {
+			log.Printf("Failed to record IGDB candidates for '%s': %v", gameName, err)
+		}
+	}
+	best := candidates[0]
+
+	info = newIGDBGameInfo(best.Game, best.Breakdown.Total)
+
+	// Resolve genres, platforms, companies, cover and screenshots in a single expanded query
+	if err := ic.fetchGameDetails(ctx, best.Game.ID, info); err != nil {
+		log.Printf("Failed to fetch details for '%s': %v", best.Game.Name, err)
+		return info, false, nil
+	}
+
+	return info, true, nil
+}
+
+// MatchCandidates searches IGDB without the cache and returns every game found with its
+// score breakdown, best first
+func (ic *IGDBClient) MatchCandidates(ctx context.Context, gameName string) ([]MatchCandidate, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	games, err := ic.searchGames(ctx, gameName)
+	if err != nil {
+		return nil, err
+	}
+	return rankCandidates(ic.scorer, gameName, games), nil
+}
+
+// searchGames searches IGDB for games from the last 20 years matching a name, newest first
+func (ic *IGDBClient) searchGames(ctx context.Context, gameName string) ([]*igdb.Game, error) {
+	// Search with a higher limit to get multiple results; they are sorted by date in our code
+	var games []*igdb.Game
+	query := fmt.Sprintf("search %s; fields %s; where first_release_date > %d; limit 50;",
+		igdbQuote(gameName), igdbGameFields, time.Now().AddDate(-20, 0, 0).Unix())
+	if err := ic.queryIGDB(ctx, "games", query, &games); err != nil {
 		return nil, fmt.Errorf("failed to search IGDB for game '%s': %w", gameName, err)
 	}
 	if len(games) == 0 {
-		return nil, fmt.Errorf("no games found for '%s'", gameName)
+		return nil, fmt.Errorf("no games found for '%s': %w", gameName, ErrNoIGDBMatch)
 	}
 
 	// Sort games by release date (newest first) to prioritize recent games
@@ -147,382 +232,209 @@ func (ic *IGDBClient) SearchGameWithImages(gameName string) (*IGDBGameInfo, erro
 		}
 		return games[i].FirstReleaseDate > games[j].FirstReleaseDate
 	})
+	return games, nil
+}
 
-	// Find the best matching game using our scoring system
-	bestGame := findBestMatch(gameName, games)
-	if bestGame == nil {
-		return nil, fmt.Errorf("no suitable match found for '%s' among %d results", gameName, len(games))
-	}
-
-	info := &IGDBGameInfo{
-		Title:     bestGame.Name,
-		Date:      int64(bestGame.FirstReleaseDate),
-		Summary:   bestGame.Summary,
-		Storyline: bestGame.Storyline,
-		IGDBURL:   fmt.Sprintf("https://www.igdb.com/games/%s", bestGame.Slug),
-	}
-
-	// Fetch cover if present
-	if bestGame.Cover != 0 {
-		if err := ic.fetchCover(ctx, bestGame.Cover, info); err != nil {
-			log.Printf("Failed to fetch cover for '%s': %v", bestGame.Name, err)
-		}
-	}
-
-	// Fetch screenshots in parallel if present
-	if len(bestGame.Screenshots) > 0 {
-		if err := ic.fetchScreenshots(ctx, bestGame.Screenshots, info, bestGame.Name); err != nil {
-			log.Printf("Failed to fetch some screenshots for '%s': %v", bestGame.Name, err)
+// GetGameByID returns the info for a specific IGDB game, using the cache when possible
+func (ic *IGDBClient) GetGameByID(ctx context.Context, gameID int) (*IGDBGameInfo, error) {
+	if ic.cache != nil {
+		info, err := ic.cache.LookupGame(gameID)
+		if err != nil {
+			log.Printf("IGDB cache lookup failed for game %d: %v", gameID, err)
+		} else if info != nil {
+			return info, nil
 		}
 	}
 
-	return info, nil
-}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
 
-// findBestMatch implements a scoring system to find the best matching game
-func findBestMatch(searchQuery string, games []*igdb.Game) *igdb.Game {
+	var games []*igdb.Game
+	query := fmt.Sprintf("fields %s; where id = %d;", igdbGameFields, gameID)
+	if err := ic.queryIGDB(ctx, "games", query, &games); err != nil {
+		return nil, fmt.Errorf("failed to get IGDB game %d: %w", gameID, err)
+	}
 	if len(games) == 0 {
-		return nil
-	}
-
-	var bestGame *igdb.Game
-	var bestScore float64
-
-	searchLower := strings.ToLower(strings.TrimSpace(searchQuery))
-
-	//log.Printf("=== FINDING BEST MATCH FOR '%s' ===", searchQuery)
-	//log.Printf("Found %d games to evaluate:", len(games))
-
-	for _, game := range games {
-		score := calculateMatchScore(searchLower, game)
-		//recencyBonus := calculateRecencyBonus(game.FirstReleaseDate)
-		//releaseDate := "Unknown"
-		//if game.FirstReleaseDate != 0 {
-		//	releaseDate = time.Unix(int64(game.FirstReleaseDate), 0).Format("2006-01-02")
-		//}
-
-		// Get game category info
-		//category := "Unknown"
-		//switch game.Category {
-		//case 0:
-		//	category = "Main Game"
-		//case 1:
-		//	category = "DLC/Add-on"
-		//case 2:
-		//	category = "Expansion"
-		//case 3:
-		//	category = "Bundle"
-		//case 4:
-		//	category = "Standalone Expansion"
-		//case 5:
-		//	category = "Mod"
-		//case 6:
-		//	category = "Episode"
-		//case 7:
-		//	category = "Season"
-		//case 8:
-		//	category = "Remake"
-		//case 9:
-		//	category = "Remaster"
-		//case 10:
-		//	category = "Expanded Game"
-		//case 11:
-		//	category = "Port"
-		//case 12:
-		//	category = "Fork"
-		//case 13:
-		//	category = "Pack"
-		//case 14:
-		//	category = "Update"
-		//}
-
-		// Get game status
-		//status := "Unknown"
-		//switch game.Status {
-		//case 0:
-		//	status = "Released"
-		//case 2:
-		//	status = "Alpha"
-		//case 3:
-		//	status = "Beta"
-		//case 4:
-		//	status = "Early Access"
-		//case 5:
-		//	status = "Offline"
-		//case 6:
-		//	status = "Cancelled"
-		//case 7:
-		//	status = "Rumored"
-		//case 8:
-		//	status = "Delisted"
-		//}
-
-		//log.Printf("  %d. '%s'", i+1, game.Name)
-		//log.Printf("      Score: %.3f (base: %.3f + recency: %.3f)", score, score-recencyBonus, recencyBonus)
-		//log.Printf("      Released: %s | Category: %s | Status: %s", releaseDate, category, status)
-		//log.Printf("      ID: %d | Rating: %.1f | Summary: %.100s...", game.ID, game.Rating, game.Summary)
-
-		if bestGame == nil || score > bestScore {
-			bestGame = game
-			bestScore = score
-		}
+		return nil, fmt.Errorf("IGDB game %d not found: %w", gameID, ErrNoIGDBMatch)
 	}
 
-	// Calculate recency bonus for logging
-	recencyBonus := calculateRecencyBonus(bestGame.FirstReleaseDate)
-	log.Printf("=== SELECTED: '%s' (final score: %.3f, recency bonus: %.3f) ===", bestGame.Name, bestScore, recencyBonus)
-	return bestGame
-}
-
-// calculateMatchScore returns a score between 0 and 1, where 1 is a perfect match
-func calculateMatchScore(searchQuery string, game *igdb.Game) float64 {
-	gameName := strings.ToLower(strings.TrimSpace(game.Name))
-	baseScore := 0.0
-
-	// Perfect exact match
-	if gameName == searchQuery {
-		baseScore = 1.0
-	} else if gameName == searchQuery {
-		// Exact word match (e.g., "subnautica" matches "Subnautica")
-		baseScore = 0.95
-	} else if strings.Contains(gameName, searchQuery) {
-		// Check if search query is contained in game name
-		if strings.HasPrefix(gameName, searchQuery) {
-			baseScore = 0.9
-		} else {
-			baseScore = 0.8
-		}
-	} else if strings.Contains(searchQuery, gameName) {
-		// Check if game name is contained in search query
-		baseScore = 0.7
-	} else {
-		// Check for word-by-word matching
-		searchWords := strings.Fields(searchQuery)
-		gameWords := strings.Fields(gameName)
-
-		wordMatches := 0
-		for _, searchWord := range searchWords {
-			for _, gameWord := range gameWords {
-				if searchWord == gameWord {
-					wordMatches++
-					break
-				}
-			}
-		}
-
-		if len(searchWords) > 0 {
-			wordScore := float64(wordMatches) / float64(len(searchWords))
-			if wordScore > 0.5 {
-				baseScore = wordScore * 0.6 // Cap at 0.6 for partial word matches
-			}
-		}
+	game := games[0]
+	info := newIGDBGameInfo(game, 0)
+	if err := ic.fetchGameDetails(ctx, game.ID, info); err != nil {
+		return nil, fmt.Errorf("failed to fetch details for IGDB game %d: %w", gameID, err)
 	}
-
-	// If we have a base score, apply recency bonus and penalties
-	if baseScore > 0 {
-		// Apply recency bonus (0.0 to 0.2 bonus for recent games)
-		recencyBonus := calculateRecencyBonus(game.FirstReleaseDate)
-		baseScore += recencyBonus
-
-		// Bonus for main games (not DLC, updates, etc.)
-		if game.Category == 0 { // Main Game
-			baseScore += 0.1
-		}
-
-		// Penalty for very old games (pre-2010)
-		if game.FirstReleaseDate != 0 {
-			releaseYear := time.Unix(int64(game.FirstReleaseDate), 0).Year()
-			if releaseYear < 2010 {
-				baseScore *= 0.5 // Heavy penalty for very old games
-			}
-		}
-
-		// Apply penalties for game packs, collections, and similar titles
-		penaltyWords := []string{
-			"pack", "collection", "bundle", "double", "triple", "quadruple",
-			"complete", "ultimate", "deluxe", "edition", "remastered",
-			"remaster", "definitive", "anniversary", "gold", "platinum",
-			"+", "plus", "and", "&", "with", "featuring", "including",
-		}
-
-		for _, penaltyWord := range penaltyWords {
-			if strings.Contains(gameName, penaltyWord) {
-				baseScore *= 0.3 // Heavy penalty for pack/collection titles
-				break
-			}
-		}
-
-		// Cap the final score at 1.0
-		if baseScore > 1.0 {
-			baseScore = 1.0
+	if ic.cache != nil {
+		if err := ic.cache.StoreGame(info); err != nil {
+			log.Printf("Failed to cache IGDB game %d: %v", gameID, err)
 		}
 	}
-
-	return baseScore
+	return info, nil
 }
 
-// calculateRecencyBonus returns a bonus score (0.0 to 0.2) based on how recent the game is
-func calculateRecencyBonus(releaseDate int) float64 {
-	if releaseDate == 0 {
-		return 0.0 // No release date, no bonus
-	}
-
-	// Convert Unix timestamp to time
-	releaseTime := time.Unix(int64(releaseDate), 0)
-	now := time.Now()
-
-	// Calculate years difference (positive for past, negative for future)
-	yearsDifference := releaseTime.Sub(now).Hours() / (24 * 365.25)
-
-	// Handle future release dates (upcoming games)
-	if yearsDifference > 0 {
-		// Future games get maximum bonus if releasing within 1 year
-		if yearsDifference <= 1 {
-			return 0.2 // Maximum bonus for games releasing soon
-		} else if yearsDifference <= 2 {
-			// Decreasing bonus for games releasing in 1-2 years
-			return 0.2 - (yearsDifference-1)*0.1
-		} else {
-			// Very distant future games get minimal bonus
-			return 0.05
-		}
+// PinMatch makes future lookups of gameName resolve to the given game, overriding the automatic match
+func (ic *IGDBClient) PinMatch(gameName string, info *IGDBGameInfo) error {
+	if ic.cache == nil {
+		return fmt.Errorf("IGDB cache is disabled")
 	}
+	return ic.cache.Pin(gameName, info.ID)
+}
 
-	// Handle past release dates (released games)
-	yearsSinceRelease := -yearsDifference // Convert to positive number
-
-	// Give maximum bonus (0.2) for games released in the last 2 years
-	// Gradually decrease bonus for older games
-	if yearsSinceRelease <= 2 {
-		return 0.2
-	} else if yearsSinceRelease <= 5 {
-		// Linear decrease from 0.2 to 0.1 over 3 years
-		return 0.2 - (yearsSinceRelease-2)*0.033
-	} else if yearsSinceRelease <= 10 {
-		// Linear decrease from 0.1 to 0.05 over 5 years
-		return 0.1 - (yearsSinceRelease-5)*0.01
-	} else {
-		// Very old games get minimal bonus
-		return 0.05
+// igdbGameFields are the game fields requested for searches and lookups; details are fetched separately
+const igdbGameFields = "name,first_release_date,summary,storyline,slug,rating,aggregated_rating,total_rating,category,status"
+
+// newIGDBGameInfo builds the basic game info from an IGDB game
+func newIGDBGameInfo(game *igdb.Game, matchScore float64) *IGDBGameInfo {
+	return &IGDBGameInfo{
+		ID:               game.ID,
+		Title:            game.Name,
+		Date:             int64(game.FirstReleaseDate),
+		Summary:          game.Summary,
+		Storyline:        game.Storyline,
+		IGDBURL:          fmt.Sprintf("https://www.igdb.com/games/%s", game.Slug),
+		Rating:           game.Rating,
+		AggregatedRating: game.AggregatedRating,
+		TotalRating:      game.TotalRating,
+		MatchScore:       matchScore,
 	}
 }
 
-// getGenres retrieves genre information for given genre IDs
-func (ic *IGDBClient) getGenres(ctx context.Context, genreIDs []int) ([]string, error) {
-	genres, err := ic.client.Genres.List(genreIDs, igdb.SetFields("name"))
+// queryIGDB posts a raw Apicalypse query to an IGDB endpoint and decodes the JSON response into result.
+// All IGDB requests go through here: unlike the igdb package it takes a context, and it can decode expanded fields.
+func (ic *IGDBClient) queryIGDB(ctx context.Context, endpoint, query string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, igdbAPIURL+endpoint, strings.NewReader(query))
 	if err != nil {
-		return nil, err
-	}
-
-	var genreNames []string
-	for _, genre := range genres {
-		genreNames = append(genreNames, genre.Name)
+		return err
 	}
+	req.Header.Set("Accept", "application/json")
 
-	return genreNames, nil
-}
-
-// getPlatforms retrieves platform information for given platform IDs
-func (ic *IGDBClient) getPlatforms(ctx context.Context, platformIDs []int) ([]string, error) {
-	platforms, err := ic.client.Platforms.List(platformIDs, igdb.SetFields("name"))
+	resp, err := ic.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("IGDB request to %s failed: %w", endpoint, err)
 	}
+	defer resp.Body.Close()
 
-	var platformNames []string
-	for _, platform := range platforms {
-		platformNames = append(platformNames, platform.Name)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("IGDB request to %s returned %s: %s", endpoint, resp.Status, strings.TrimSpace(string(body)))
 	}
-
-	return platformNames, nil
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode IGDB %s response: %w", endpoint, err)
+	}
+	return nil
 }
 
-// formatReleaseDate formats a Unix timestamp to a readable date
-func formatReleaseDate(timestamp int64) string {
-	if timestamp == 0 {
-		return "Unknown"
-	}
-	return time.Unix(timestamp, 0).Format("2006-01-02")
+// igdbQuote quotes a string for an Apicalypse query
+func igdbQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
 }
 
-// formatGenres formats a slice of genre names into a comma-separated string
-func formatGenres(genres []string) string {
-	if len(genres) == 0 {
-		return "Unknown"
-	}
-	return strings.Join(genres, ", ")
+// igdbNamed is an expanded IGDB reference that only carries a name
+type igdbNamed struct {
+	Name string `json:"name"`
 }
 
-// formatPlatforms formats a slice of platform names into a comma-separated string
-func formatPlatforms(platforms []string) string {
-	if len(platforms) == 0 {
-		return "Unknown"
-	}
-	return strings.Join(platforms, ", ")
+// igdbImage is an expanded cover or screenshot reference
+type igdbImage struct {
+	ImageID string `json:"image_id"`
 }
 
-// formatSummary truncates a summary to a reasonable length
-func formatSummary(summary string, maxLen int) string {
-	if len(summary) <= maxLen {
-		return summary
+// maxIGDBScreenshots caps how many screenshot URLs are kept per game
+const maxIGDBScreenshots = 10
+
+// fetchGameDetails resolves genres, platforms, game modes, themes, companies, franchise,
+// cover and screenshots for a game in one request
+func (ic *IGDBClient) fetchGameDetails(ctx context.Context, gameID int, info *IGDBGameInfo) error {
+	var games []struct {
+		Cover             *igdbImage  `json:"cover"`
+		Screenshots       []igdbImage `json:"screenshots"`
+		Genres            []igdbNamed `json:"genres"`
+		Platforms         []igdbNamed `json:"platforms"`
+		GameModes         []igdbNamed `json:"game_modes"`
+		Themes            []igdbNamed `json:"themes"`
+		Franchise         *igdbNamed  `json:"franchise"`
+		Franchises        []igdbNamed `json:"franchises"`
+		InvolvedCompanies []struct {
+			Company   igdbNamed `json:"company"`
+			Developer bool      `json:"developer"`
+			Publisher bool      `json:"publisher"`
+		} `json:"involved_companies"`
+	}
+
+	query := fmt.Sprintf(`fields cover.image_id,screenshots.image_id,`+
+		`genres.name,platforms.name,game_modes.name,themes.name,franchise.name,franchises.name,`+
+		`involved_companies.company.name,involved_companies.developer,involved_companies.publisher; where id = %d;`, gameID)
+	if err := ic.queryIGDB(ctx, "games", query, &games); err != nil {
+		return err
+	}
+	if len(games) == 0 {
+		return fmt.Errorf("game %d not found", gameID)
 	}
-	return summary[:maxLen-3] + "..."
-}
 
-// fetchCover fetches the cover image for a game
-func (ic *IGDBClient) fetchCover(ctx context.Context, coverID int, info *IGDBGameInfo) error {
-	cover, err := ic.client.Covers.Get(coverID, igdb.SetFields("url,image_id,width,height"))
-	if err != nil {
-		return fmt.Errorf("failed to get cover: %w", err)
+	game := games[0]
+	if game.Cover != nil && game.Cover.ImageID != "" {
+		info.CoverURL = igdbImageURL(game.Cover.ImageID)
 	}
-	if cover == nil || cover.Image.ImageID == "" {
-		return fmt.Errorf("no valid cover image found")
+	for _, sc := range game.Screenshots {
+		if sc.ImageID == "" {
+			continue
+		}
+		if len(info.Screenshots) >= maxIGDBScreenshots {
+			break
+		}
+		info.Screenshots = append(info.Screenshots, igdbImageURL(sc.ImageID))
+	}
+	info.Genres = igdbNames(game.Genres)
+	info.Platforms = igdbNames(game.Platforms)
+	info.GameModes = igdbNames(game.GameModes)
+	info.Themes = igdbNames(game.Themes)
+	if game.Franchise != nil && game.Franchise.Name != "" {
+		info.Franchise = game.Franchise.Name
+	} else if len(game.Franchises) > 0 {
+		info.Franchise = game.Franchises[0].Name
+	}
+	for _, involved := range game.InvolvedCompanies {
+		if involved.Company.Name == "" {
+			continue
+		}
+		if involved.Developer {
+			info.Developers = append(info.Developers, involved.Company.Name)
+		}
+		if involved.Publisher {
+			info.Publishers = append(info.Publishers, involved.Company.Name)
+		}
 	}
 
-	info.CoverURL = fmt.Sprintf("https://images.igdb.com/igdb/image/upload/t_original/%s.webp", cover.Image.ImageID)
 	return nil
 }
 
-// fetchScreenshots fetches screenshots in parallel
-func (ic *IGDBClient) fetchScreenshots(ctx context.Context, screenshotIDs []int, info *IGDBGameInfo, gameName string) error {
-	// Create a channel to collect results
-	type screenshotResult struct {
-		url string
-		err error
-	}
-	resultChan := make(chan screenshotResult, len(screenshotIDs))
-
-	// Launch goroutines for each screenshot
-	for _, id := range screenshotIDs {
-		go func(screenshotID int) {
-			sc, err := ic.client.Screenshots.Get(screenshotID, igdb.SetFields("url,image_id,width,height"))
-			if err != nil {
-				resultChan <- screenshotResult{err: fmt.Errorf("failed to get screenshot %d: %w", screenshotID, err)}
-				return
-			}
-			if sc == nil || sc.Image.ImageID == "" {
-				resultChan <- screenshotResult{err: fmt.Errorf("no valid screenshot image for ID %d", screenshotID)}
-				return
-			}
+// igdbImageURL builds the full-size image URL for an IGDB image ID
+func igdbImageURL(imageID string) string {
+	return fmt.Sprintf("https://images.igdb.com/igdb/image/upload/t_original/%s.webp", imageID)
+}
 
-			url := fmt.Sprintf("https://images.igdb.com/igdb/image/upload/t_original/%s.webp", sc.Image.ImageID)
-			resultChan <- screenshotResult{url: url}
-		}(id)
+// igdbNames extracts the names from a list of expanded references
+func igdbNames(refs []igdbNamed) []string {
+	var names []string
+	for _, ref := range refs {
+		if ref.Name != "" {
+			names = append(names, ref.Name)
+		}
 	}
+	return names
+}
 
-	// Collect results
-	for i := 0; i < len(screenshotIDs); i++ {
-		select {
-		case result := <-resultChan:
-			if result.err != nil {
-				log.Printf("Screenshot fetch error for '%s': %v", gameName, result.err)
-			} else {
-				info.Screenshots = append(info.Screenshots, result.url)
-			}
-		case <-ctx.Done():
-			return fmt.Errorf("timeout while fetching screenshots: %w", ctx.Err())
-		}
+// formatReleaseDate formats a Unix timestamp to a readable date
+func formatReleaseDate(timestamp int64) string {
+	if timestamp == 0 {
+		return "Unknown"
 	}
+	return time.Unix(timestamp, 0).Format("2006-01-02")
+}
 
-	return nil
+// formatRating formats a 0-100 IGDB rating
+func formatRating(rating float64) string {
+	if rating <= 0 {
+		return "Unknown"
+	}
+	return fmt.Sprintf("%.1f/100", rating)
 }
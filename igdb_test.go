@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// redirectTransport sends every request to the test server instead of its real host
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = rt.target.Scheme
+	req.URL.Host = rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// fakeIGDB answers game searches with Portal 2 and fails detail queries while failDetails is set
+type fakeIGDB struct {
+	failDetails int32
+	searches    int32
+}
+
+func (f *fakeIGDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	query := string(body)
+	switch {
+	case r.URL.Path != "/v4/games":
+		http.NotFound(w, r)
+	case strings.HasPrefix(query, "search"):
+		atomic.AddInt32(&f.searches, 1)
+		w.Write([]byte(`[{"id":72,"name":"Portal 2","first_release_date":1303171200}]`))
+	case strings.HasPrefix(query, "fields cover") && atomic.LoadInt32(&f.failDetails) != 0:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	case strings.HasPrefix(query, "fields cover"):
+		w.Write([]byte(`[{"cover":{"image_id":"co1rs4"},"genres":[{"name":"Puzzle"}]}]`))
+	default:
+		// A lookup by ID: fields ...; where id = N;
+		_, id, _ := strings.Cut(query, "where id = ")
+		id = strings.TrimSuffix(id, ";")
+		fmt.Fprintf(w, `[{"id":%s,"name":"Game %s"}]`, id, id)
+	}
+}
+
+// newTestIGDBClient returns a client talking to a fakeIGDB, with a cache in a fresh database
+func newTestIGDBClient(t *testing.T) (*IGDBClient, *fakeIGDB) {
+	t.Helper()
+	fake := &fakeIGDB{}
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	return &IGDBClient{
+		httpClient: &http.Client{Transport: redirectTransport{target}},
+		cache:      NewIGDBCache(newTestDB(t), time.Hour, time.Hour),
+		scorer:     defaultMatchScorer{},
+	}, fake
+}
+
+func TestSearchGameWithImagesSkipsCacheWithoutDetails(t *testing.T) {
+	ic, fake := newTestIGDBClient(t)
+	atomic.StoreInt32(&fake.failDetails, 1)
+
+	info, err := ic.SearchGameWithImages(context.Background(), "Portal 2")
+	if err != nil || info == nil || info.ID != 72 {
+		t.Fatalf("SearchGameWithImages = %+v, %v; want the match without details", info, err)
+	}
+	if _, found, _ := ic.cache.Lookup("Portal 2"); found {
+		t.Error("match without details was cached")
+	}
+
+	// Once the details load, the complete match is cached
+	atomic.StoreInt32(&fake.failDetails, 0)
+	info, err = ic.SearchGameWithImages(context.Background(), "Portal 2")
+	if err != nil || info.CoverURL == "" || len(info.Genres) != 1 {
+		t.Fatalf("SearchGameWithImages = %+v, %v; want the details", info, err)
+	}
+	if cached, found, _ := ic.cache.Lookup("Portal 2"); !found || cached.CoverURL != info.CoverURL {
+		t.Errorf("cache = %+v, %v; want the complete match", cached, found)
+	}
+	if _, err := ic.SearchGameWithImages(context.Background(), "Portal 2"); err != nil || fake.searches != 2 {
+		t.Errorf("%d searches, %v; want the third lookup served from the cache", fake.searches, err)
+	}
+}
+
+func TestGetGameByIDReturnsDetailErrors(t *testing.T) {
+	ic, fake := newTestIGDBClient(t)
+	atomic.StoreInt32(&fake.failDetails, 1)
+
+	if info, err := ic.GetGameByID(context.Background(), 72); err == nil || !strings.Contains(err.Error(), "details") {
+		t.Errorf("GetGameByID = %+v, %v; want the details error", info, err)
+	}
+	atomic.StoreInt32(&fake.failDetails, 0)
+	if info, err := ic.GetGameByID(context.Background(), 72); err != nil || info.CoverURL == "" {
+		t.Errorf("GetGameByID = %+v, %v; want the game with details", info, err)
+	}
+}
+
+func TestSearchGameWithImagesUsesPin(t *testing.T) {
+	ic, fake := newTestIGDBClient(t)
+	if err := ic.cache.StoreGame(&IGDBGameInfo{ID: 620, Title: "Portal"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ic.PinMatch("Portal 2", &IGDBGameInfo{ID: 620, Title: "Portal"}); err != nil {
+		t.Fatal(err)
+	}
+	ic.cache.InvalidateAll()
+
+	// The pinned game is fetched by ID once its cache entry is gone; no search runs
+	info, err := ic.SearchGameWithImages(context.Background(), "Portal 2")
+	if err != nil || info.ID != 620 || fake.searches != 0 {
+		t.Fatalf("SearchGameWithImages = %+v, %v after %d searches; want the pinned game by ID", info, err, fake.searches)
+	}
+	if cached, _ := ic.cache.LookupGame(620); cached == nil {
+		t.Error("game fetched by ID was not cached")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// twitchTokenURL is the Twitch OAuth2 endpoint that issues IGDB app access tokens
+const twitchTokenURL = "https://id.twitch.tv/oauth2/token"
+
+// igdbTokenRefreshMargin is how long before expiry a token is renewed.
+// Short-lived tokens are renewed after 90% of their lifetime instead.
+const igdbTokenRefreshMargin = 24 * time.Hour
+
+// IGDBTokenSource hands out Twitch app access tokens and renews them before they expire.
+// It is safe for concurrent use; only one refresh runs at a time. Tokens are kept in the
+// credential store, when there is one, so restarts reuse them.
+type IGDBTokenSource struct {
+	clientID     string
+	clientSecret string
+	tokenURL     string
+	httpClient   *http.Client
+	store        *CredentialStore
+
+	mu        sync.Mutex
+	token     string
+	expiry    time.Time
+	refreshAt time.Time
+}
+
+// NewIGDBTokenSource creates a token source for the given Twitch application credentials;
+// store may be nil
+func NewIGDBTokenSource(clientID, clientSecret string, store *CredentialStore) *IGDBTokenSource {
+	return &IGDBTokenSource{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		tokenURL:     twitchTokenURL,
+		httpClient:   &http.Client{Timeout: 30 * time.Second},
+		store:        store,
+	}
+}
+
+// Token returns a valid access token, fetching a new one if the current one is missing or about to expire
+func (ts *IGDBTokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token == "" {
+		ts.loadToken()
+	}
+	if ts.token != "" && time.Now().Before(ts.refreshAt) {
+		return ts.token, nil
+	}
+
+	token, expiresIn, err := ts.fetchToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	ts.token = token
+	ts.expiry = time.Now().Add(expiresIn)
+	ts.refreshAt = igdbTokenRefreshAt(ts.expiry, expiresIn)
+	log.Printf("Obtained new IGDB access token, expires at %s", ts.expiry.Format(time.RFC3339))
+	if err := ts.store.Set(credentialIGDBToken+ts.clientID, ts.token, ts.expiry); err != nil {
+		log.Printf("Failed to save IGDB access token: %v", err)
+	}
+	return ts.token, nil
+}
+
+// loadToken picks up the token saved by an earlier run, if it is not due for renewal
+func (ts *IGDBTokenSource) loadToken() {
+	token, expiry, err := ts.store.Get(credentialIGDBToken + ts.clientID)
+	if err != nil {
+		log.Printf("Failed to read saved IGDB access token: %v", err)
+		return
+	}
+	if token == "" || expiry.IsZero() {
+		return
+	}
+	ts.token = token
+	ts.expiry = expiry
+	// The original lifetime is not stored, so the margin is based on the time left
+	ts.refreshAt = igdbTokenRefreshAt(expiry, time.Until(expiry))
+}
+
+// igdbTokenRefreshAt returns when a token expiring at expiry, valid for lifetime,
+// is renewed: igdbTokenRefreshMargin before expiry, or after 90% of a shorter lifetime
+func igdbTokenRefreshAt(expiry time.Time, lifetime time.Duration) time.Time {
+	margin := igdbTokenRefreshMargin
+	if margin > lifetime/10 {
+		margin = lifetime / 10
+	}
+	return expiry.Add(-margin)
+}
+
+// Invalidate drops the given token so the next call to Token fetches a new one.
+// Tokens that were already replaced by another goroutine are left alone.
+func (ts *IGDBTokenSource) Invalidate(token string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token == token {
+		ts.token = ""
+		ts.expiry = time.Time{}
+		ts.refreshAt = time.Time{}
+		if err := ts.store.Delete(credentialIGDBToken + ts.clientID); err != nil {
+			log.Printf("Failed to delete saved IGDB access token: %v", err)
+		}
+	}
+}
+
+// fetchToken requests a new app access token using the client credentials grant
+func (ts *IGDBTokenSource) fetchToken(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{
+		"client_id":     {ts.clientID},
+		"client_secret": {ts.clientSecret},
+		"grant_type":    {"client_credentials"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := ts.httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to request IGDB access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", 0, fmt.Errorf("IGDB token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", 0, fmt.Errorf("failed to decode IGDB token response: %w", err)
+	}
+	if res.AccessToken == "" {
+		return "", 0, fmt.Errorf("IGDB token response did not contain an access token")
+	}
+	if !strings.EqualFold(res.TokenType, "bearer") && res.TokenType != "" {
+		return "", 0, fmt.Errorf("unexpected IGDB token type %q", res.TokenType)
+	}
+
+	return res.AccessToken, time.Duration(res.ExpiresIn) * time.Second, nil
+}
+
+// IGDBAuthTransport handles OAuth2 authentication for IGDB
+type IGDBAuthTransport struct {
+	Source    *IGDBTokenSource
+	ClientID  string
+	Transport http.RoundTripper
+}
+
+// RoundTrip adds the IGDB auth headers and retries once with a fresh token on 401
+func (t *IGDBAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.Transport.RoundTrip(t.authorize(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The request body has been consumed; we can only retry if it can be recreated
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	log.Printf("IGDB rejected access token, refreshing and retrying")
+	t.Source.Invalidate(token)
+	token, err = t.Source.Token(req.Context())
+	if err != nil {
+		return resp, nil
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	retry := t.authorize(req, token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.Transport.RoundTrip(retry)
+}
+
+// authorize returns a copy of req carrying the IGDB auth headers
+func (t *IGDBAuthTransport) authorize(req *http.Request, token string) *http.Request {
+	out := req.Clone(req.Context())
+	out.Header.Set("Authorization", "Bearer "+token)
+	out.Header.Set("Client-ID", t.ClientID)
+	return out
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestTokenEndpoint serves Twitch-style token responses, issuing token-1, token-2, ...
+// valid for expiresIn seconds, and counts the requests it answers
+func newTestTokenEndpoint(t *testing.T, expiresIn int) (string, *int32) {
+	t.Helper()
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "client" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		n := atomic.AddInt32(&calls, 1)
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d,"token_type":"bearer"}`, n, expiresIn)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &calls
+}
+
+func newTestTokenSource(tokenURL string, store *CredentialStore) *IGDBTokenSource {
+	ts := NewIGDBTokenSource("client", "secret", store)
+	ts.tokenURL = tokenURL
+	return ts
+}
+
+func TestIGDBTokenSourceReusesToken(t *testing.T) {
+	tokenURL, calls := newTestTokenEndpoint(t, 5000000)
+	ts := newTestTokenSource(tokenURL, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := ts.Token(context.Background()); err != nil || token != "token-1" {
+				t.Errorf("Token = %q, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if *calls != 1 {
+		t.Errorf("token endpoint called %d times, want 1", *calls)
+	}
+}
+
+func TestIGDBTokenSourceInvalidate(t *testing.T) {
+	tokenURL, calls := newTestTokenEndpoint(t, 5000000)
+	ts := newTestTokenSource(tokenURL, nil)
+
+	first, _ := ts.Token(context.Background())
+	ts.Invalidate("some-other-token")
+	if token, _ := ts.Token(context.Background()); token != first {
+		t.Errorf("invalidating a stale token replaced the current one")
+	}
+	ts.Invalidate(first)
+	if token, _ := ts.Token(context.Background()); token != "token-2" {
+		t.Errorf("Token after Invalidate = %q, want token-2", token)
+	}
+	if *calls != 2 {
+		t.Errorf("token endpoint called %d times, want 2", *calls)
+	}
+}
+
+func TestIGDBTokenRefreshAt(t *testing.T) {
+	expiry := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		lifetime time.Duration
+		want     time.Time
+	}{
+		{60 * 24 * time.Hour, expiry.Add(-24 * time.Hour)},
+		{10 * time.Hour, expiry.Add(-time.Hour)},
+		{0, expiry},
+	}
+	for _, tc := range cases {
+		if got := igdbTokenRefreshAt(expiry, tc.lifetime); !got.Equal(tc.want) {
+			t.Errorf("igdbTokenRefreshAt(%s) = %v, want %v", tc.lifetime, got, tc.want)
+		}
+	}
+}
+
+func TestIGDBTokenSourceLoadsSavedToken(t *testing.T) {
+	tokenURL, calls := newTestTokenEndpoint(t, 5000000)
+	store := NewCredentialStore(newTestDB(t))
+
+	// A saved token with less than igdbTokenRefreshMargin left is still used
+	if err := store.Set(credentialIGDBToken+"client", "saved", time.Now().Add(10*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	ts := newTestTokenSource(tokenURL, store)
+	for i := 0; i < 3; i++ {
+		if token, err := ts.Token(context.Background()); err != nil || token != "saved" {
+			t.Fatalf("Token = %q, %v; want the saved token", token, err)
+		}
+	}
+	if *calls != 0 {
+		t.Errorf("token endpoint called %d times, want 0", *calls)
+	}
+
+	// A fetched token is saved for the next run
+	ts.Invalidate("saved")
+	token, err := ts.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, _, err := store.Get(credentialIGDBToken + "client")
+	if err != nil || saved != token {
+		t.Errorf("saved token = %q, %v; want %q", saved, err, token)
+	}
+}
+
+func TestIGDBTokenSourceEndpointError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"status":403,"message":"invalid client secret"}`, http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	_, err := newTestTokenSource(srv.URL, nil).Token(context.Background())
+	if err == nil {
+		t.Fatal("expected an error from a 403 response")
+	}
+}
+
+func TestIGDBAuthTransportRetriesOn401(t *testing.T) {
+	tokenURL, calls := newTestTokenEndpoint(t, 5000000)
+	ts := newTestTokenSource(tokenURL, nil)
+
+	var seen []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		seen = append(seen, r.Header.Get("Authorization")+" "+string(body))
+		if r.Header.Get("Client-ID") != "client" || r.Header.Get("Authorization") == "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer api.Close()
+
+	client := &http.Client{Transport: &IGDBAuthTransport{Source: ts, ClientID: "client", Transport: http.DefaultTransport}}
+	req, _ := http.NewRequest(http.MethodPost, api.URL, nil)
+	req.Body = io.NopCloser(strings.NewReader("fields name;"))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("fields name;")), nil }
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200 after retry", resp.StatusCode)
+	}
+	want := []string{"Bearer token-1 fields name;", "Bearer token-2 fields name;"}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Errorf("requests = %q, want %q", seen, want)
+	}
+	if *calls != 2 {
+		t.Errorf("token endpoint called %d times, want 2", *calls)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrNoIGDBMatch is returned when IGDB has no suitable game for a query
+var ErrNoIGDBMatch = errors.New("no IGDB match")
+
+// IGDBCache stores resolved IGDB lookups in SQLite so repeat releases cost no API calls
+type IGDBCache struct {
+	db          *sql.DB
+	ttl         time.Duration
+	negativeTTL time.Duration
+	readOnly    bool // lookups only; stores are skipped
+}
+
+// NewIGDBCache creates a cache; negativeTTL applies to queries that had no match
+func NewIGDBCache(db *sql.DB, ttl, negativeTTL time.Duration) *IGDBCache {
+	return &IGDBCache{db: db, ttl: ttl, negativeTTL: negativeTTL}
+}
+
+// ReadOnly returns a cache that serves lookups from the same tables but stores nothing,
+// for commands such as dry-run that must leave the database as they found it
+func (c *IGDBCache) ReadOnly() *IGDBCache {
+	ro := *c
+	ro.readOnly = true
+	return &ro
+}
+
+var cacheKeyCleaner = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// normalizeCacheKey folds case, punctuation and whitespace so equivalent queries share a cache entry
+func normalizeCacheKey(query string) string {
+	return strings.TrimSpace(cacheKeyCleaner.ReplaceAllString(strings.ToLower(query), " "))
+}
+
+// Lookup returns the cached result for a query. found is false on a cache miss;
+// a found entry with a nil info is a cached negative result.
+func (c *IGDBCache) Lookup(query string) (info *IGDBGameInfo, found bool, err error) {
+	var gameID int
+	var expiresAt int64
+	err = c.db.QueryRow(`SELECT game_id, expires_at FROM igdb_query_cache WHERE query = ?`, normalizeCacheKey(query)).Scan(&gameID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if time.Now().Unix() >= expiresAt {
+		return nil, false, nil
+	}
+	if gameID == 0 {
+		return nil, true, nil
+	}
+
+	info, err = c.LookupGame(gameID)
+	if err != nil || info == nil {
+		return nil, false, err
+	}
+	return info, true, nil
+}
+
+// LookupGame returns the cached info for an IGDB game ID, or nil if absent or expired
+func (c *IGDBCache) LookupGame(gameID int) (*IGDBGameInfo, error) {
+	var data string
+	var expiresAt int64
+	err := c.db.QueryRow(`SELECT info, expires_at FROM igdb_game_cache WHERE game_id = ?`, gameID).Scan(&data, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= expiresAt {
+		return nil, nil
+	}
+
+	var info IGDBGameInfo
+	if err := json.Unmarshal([]byte(data), &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Store caches a successful lookup under both the query and the game ID
+func (c *IGDBCache) Store(query string, info *IGDBGameInfo) error {
+	if c.readOnly {
+		return nil
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	expiresAt := now.Add(c.ttl).Unix()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO igdb_game_cache (game_id, info, fetched_at, expires_at) VALUES (?, ?, ?, ?)`,
+		info.ID, string(data), now.Unix(), expiresAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO igdb_query_cache (query, game_id, fetched_at, expires_at) VALUES (?, ?, ?, ?)`,
+		normalizeCacheKey(query), info.ID, now.Unix(), expiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StoreGame caches the info of a game looked up by ID
+func (c *IGDBCache) StoreGame(info *IGDBGameInfo) error {
+	if c.readOnly {
+		return nil
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	_, err = c.db.Exec(`INSERT OR REPLACE INTO igdb_game_cache (game_id, info, fetched_at, expires_at) VALUES (?, ?, ?, ?)`,
+		info.ID, string(data), now.Unix(), now.Add(c.ttl).Unix())
+	return err
+}
+
+// StoreNegative caches the fact that a query had no match, using the shorter negative TTL
+func (c *IGDBCache) StoreNegative(query string) error {
+	if c.readOnly {
+		return nil
+	}
+	now := time.Now()
+	_, err := c.db.Exec(`INSERT OR REPLACE INTO igdb_query_cache (query, game_id, fetched_at, expires_at) VALUES (?, 0, ?, ?)`,
+		normalizeCacheKey(query), now.Unix(), now.Add(c.negativeTTL).Unix())
+	return err
+}
+
+// StoreCandidates records the ranked candidates of a search, replacing those of the query's previous search
+func (c *IGDBCache) StoreCandidates(query string, candidates []MatchCandidate) error {
+	if c.readOnly {
+		return nil
+	}
+	key := normalizeCacheKey(query)
+	now := time.Now().Unix()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM match_candidates WHERE query = ?`, key); err != nil {
+		return err
+	}
+	for i, cand := range candidates {
+		b := cand.Breakdown
+		if _, err := tx.Exec(`INSERT INTO match_candidates (query, rank, game_id, name, release_date, category,
+			base, recency, category_bonus, age_penalty, penalty_word, word_penalty, score, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			key, i+1, cand.Game.ID, cand.Game.Name, cand.Game.FirstReleaseDate, int(cand.Game.Category),
+			b.Base, b.Recency, b.Category, b.AgePenalty, b.PenaltyWord, b.WordPenalty, b.Total, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Pin makes a query resolve to the given game until it is pinned to another one.
+// Pins are kept apart from the cache, so they neither expire nor get invalidated.
+func (c *IGDBCache) Pin(query string, gameID int) error {
+	if c.readOnly {
+		return nil
+	}
+	_, err := c.db.Exec(`INSERT OR REPLACE INTO igdb_pins (query, game_id, created_at) VALUES (?, ?, ?)`,
+		normalizeCacheKey(query), gameID, time.Now().Unix())
+	return err
+}
+
+// LookupPin returns the game a query is pinned to, or 0 if it is not pinned
+func (c *IGDBCache) LookupPin(query string) (int, error) {
+	var gameID int
+	err := c.db.QueryRow(`SELECT game_id FROM igdb_pins WHERE query = ?`, normalizeCacheKey(query)).Scan(&gameID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return gameID, err
+}
+
+// InvalidateQuery removes the cached result and match candidates for a query and returns
+// the number of cached results removed
+func (c *IGDBCache) InvalidateQuery(query string) (int64, error) {
+	key := normalizeCacheKey(query)
+	if _, err := c.db.Exec(`DELETE FROM match_candidates WHERE query = ?`, key); err != nil {
+		return 0, err
+	}
+	res, err := c.db.Exec(`DELETE FROM igdb_query_cache WHERE query = ?`, key)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// InvalidateGame removes a cached game and every query that resolved to it, along with
+// the match candidates of those queries
+func (c *IGDBCache) InvalidateGame(gameID int) (int64, error) {
+	if _, err := c.db.Exec(`DELETE FROM match_candidates WHERE query IN
+		(SELECT query FROM igdb_query_cache WHERE game_id = ?)`, gameID); err != nil {
+		return 0, err
+	}
+	res, err := c.db.Exec(`DELETE FROM igdb_game_cache WHERE game_id = ?`, gameID)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	res, err = c.db.Exec(`DELETE FROM igdb_query_cache WHERE game_id = ?`, gameID)
+	if err != nil {
+		return n, err
+	}
+	m, _ := res.RowsAffected()
+	return n + m, nil
+}
+
+// InvalidateAll empties the cache and the stored match candidates. The returned count
+// covers cached queries and games only.
+func (c *IGDBCache) InvalidateAll() (int64, error) {
+	if _, err := c.db.Exec(`DELETE FROM match_candidates`); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, table := range []string{"igdb_query_cache", "igdb_game_cache"} {
+		res, err := c.db.Exec(`DELETE FROM ` + table)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
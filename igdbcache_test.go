@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Henry-Sarabia/igdb/v2"
+)
+
+func countRows(t *testing.T, cache *IGDBCache, table string) int {
+	t.Helper()
+	var n int
+	if err := cache.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestIGDBCacheLookup(t *testing.T) {
+	cache := NewIGDBCache(newTestDB(t), time.Hour, time.Hour)
+	if err := cache.Store("Portal 2", &IGDBGameInfo{ID: 72, Title: "Portal 2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.StoreNegative("Half-Life 3"); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, found, err := cache.Lookup("portal-2"); err != nil || !found || info == nil || info.ID != 72 {
+		t.Errorf("Lookup(portal-2) = %+v, %v, %v", info, found, err)
+	}
+	if info, found, err := cache.Lookup("Half Life 3"); err != nil || !found || info != nil {
+		t.Errorf("Lookup(Half Life 3) = %+v, %v, %v; want a cached miss", info, found, err)
+	}
+	if _, found, err := cache.Lookup("Portal"); err != nil || found {
+		t.Errorf("Lookup(Portal) found = %v, %v; want a cache miss", found, err)
+	}
+
+	if n, err := cache.InvalidateGame(72); err != nil || n != 2 {
+		t.Errorf("InvalidateGame = %d, %v; want the game and its query removed", n, err)
+	}
+	if _, found, _ := cache.Lookup("Portal 2"); found {
+		t.Error("invalidated game still cached")
+	}
+}
+
+func TestIGDBCacheReadOnly(t *testing.T) {
+	cache := NewIGDBCache(newTestDB(t), time.Hour, time.Hour)
+	if err := cache.Store("Portal 2", &IGDBGameInfo{ID: 72, Title: "Portal 2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ro := cache.ReadOnly()
+	if info, found, err := ro.Lookup("Portal 2"); err != nil || !found || info.ID != 72 {
+		t.Errorf("read-only Lookup = %+v, %v, %v", info, found, err)
+	}
+	candidates := []MatchCandidate{{Game: &igdb.Game{ID: 72, Name: "Portal 2"}}}
+	for _, err := range []error{
+		ro.Store("Half-Life 2", &IGDBGameInfo{ID: 233, Title: "Half-Life 2"}),
+		ro.StoreNegative("Half-Life 3"),
+		ro.StoreCandidates("Portal 2", candidates),
+	} {
+		if err != nil {
+			t.Errorf("read-only store: %v", err)
+		}
+	}
+	if n := countRows(t, cache, "igdb_query_cache"); n != 1 {
+		t.Errorf("igdb_query_cache has %d rows after read-only stores, want 1", n)
+	}
+	if n := countRows(t, cache, "igdb_game_cache"); n != 1 {
+		t.Errorf("igdb_game_cache has %d rows after read-only stores, want 1", n)
+	}
+	if n := countRows(t, cache, "match_candidates"); n != 0 {
+		t.Errorf("match_candidates has %d rows after read-only stores, want 0", n)
+	}
+}
+
+func TestIGDBCachePins(t *testing.T) {
+	cache := NewIGDBCache(newTestDB(t), time.Hour, time.Hour)
+	if gameID, err := cache.LookupPin("Portal 2"); err != nil || gameID != 0 {
+		t.Errorf("LookupPin before pinning = %d, %v", gameID, err)
+	}
+	if err := cache.Pin("Portal 2", 72); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.ReadOnly().Pin("Portal 2", 620); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pins outlive every kind of cache invalidation
+	cache.InvalidateQuery("Portal 2")
+	cache.InvalidateGame(72)
+	cache.InvalidateAll()
+	if gameID, err := cache.LookupPin("portal-2"); err != nil || gameID != 72 {
+		t.Errorf("LookupPin = %d, %v; want the pin to 72 kept", gameID, err)
+	}
+}
+
+func TestIGDBCacheInvalidateCandidates(t *testing.T) {
+	cache := NewIGDBCache(newTestDB(t), time.Hour, time.Hour)
+	store := func() {
+		t.Helper()
+		for _, q := range []struct {
+			query string
+			id    int
+		}{{"Portal 2", 72}, {"Half-Life 2", 233}} {
+			if err := cache.Store(q.query, &IGDBGameInfo{ID: q.id, Title: q.query}); err != nil {
+				t.Fatal(err)
+			}
+			candidates := []MatchCandidate{{Game: &igdb.Game{ID: q.id, Name: q.query}}, {Game: &igdb.Game{ID: 1, Name: "Other"}}}
+			if err := cache.StoreCandidates(q.query, candidates); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	store()
+	if n, err := cache.InvalidateQuery("portal-2"); err != nil || n != 1 {
+		t.Errorf("InvalidateQuery = %d, %v; want 1 query removed", n, err)
+	}
+	if n := countRows(t, cache, "match_candidates"); n != 2 {
+		t.Errorf("match_candidates has %d rows after InvalidateQuery, want the 2 of the other query", n)
+	}
+
+	store()
+	if n, err := cache.InvalidateGame(233); err != nil || n != 2 {
+		t.Errorf("InvalidateGame = %d, %v; want the game and its query removed", n, err)
+	}
+	if n := countRows(t, cache, "match_candidates"); n != 2 {
+		t.Errorf("match_candidates has %d rows after InvalidateGame, want the 2 of the other query", n)
+	}
+
+	store()
+	if n, err := cache.InvalidateAll(); err != nil || n != 4 {
+		t.Errorf("InvalidateAll = %d, %v; want 2 queries and 2 games removed", n, err)
+	}
+	if n := countRows(t, cache, "match_candidates"); n != 0 {
+		t.Errorf("match_candidates has %d rows after InvalidateAll, want 0", n)
+	}
+}
@@ -2,103 +2,121 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
+	_ "image/gif"
 	"image/jpeg"
 	"image/png"
-	"io"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/buckket/go-blurhash"
 	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp"
 	"maunium.net/go/mautrix"
+	"maunium.net/go/mautrix/crypto/attachment"
+	"maunium.net/go/mautrix/event"
 )
 
 // MatrixImageInfo is a struct for Matrix image info
 type MatrixImageInfo struct {
-	Mimetype      string                 `json:"mimetype,omitempty"`
-	Size          int                    `json:"size,omitempty"`
-	W             int                    `json:"w,omitempty"`
-	H             int                    `json:"h,omitempty"`
-	ThumbnailURL  string                 `json:"thumbnail_url,omitempty"`
-	ThumbnailInfo *MatrixImageInfo       `json:"thumbnail_info,omitempty"`
-	Additional    map[string]interface{} `json:"-"`
+	Mimetype      string                   `json:"mimetype,omitempty"`
+	Size          int                      `json:"size,omitempty"`
+	W             int                      `json:"w,omitempty"`
+	H             int                      `json:"h,omitempty"`
+	ThumbnailURL  string                   `json:"thumbnail_url,omitempty"`
+	ThumbnailFile *event.EncryptedFileInfo `json:"thumbnail_file,omitempty"`
+	ThumbnailInfo *MatrixImageInfo         `json:"thumbnail_info,omitempty"`
+	Additional    map[string]interface{}   `json:"-"`
 }
 
-// downloadImage downloads an image from a URL and returns the image.Image, its bytes, and format
-func downloadImage(url string) (image.Image, []byte, string, error) {
-	log.Printf("Attempting to download image: %s", url)
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("HTTP request error: %v", err)
-		return nil, nil, "", err
-	}
-	defer resp.Body.Close()
-
-	//log.Printf("HTTP Status: %s", resp.Status)
-	//contentType := resp.Header.Get("Content-Type")
-	//log.Printf("Content-Type: %s", contentType)
+// imageExtensions maps the formats we decode to their file extension
+var imageExtensions = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"webp": ".webp",
+}
 
-	imgBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Failed to read body: %v", err)
-		return nil, nil, "", err
-	}
-	if len(imgBytes) < 32 {
-		log.Printf("Image bytes too short: %d", len(imgBytes))
+// imageMimetype detects the mimetype of encoded image bytes
+func imageMimetype(data []byte) string {
+	mimetype := http.DetectContentType(data)
+	if !strings.HasPrefix(mimetype, "image/") {
+		return "application/octet-stream"
 	}
-	// Print first 16 bytes as hex for debugging
-	//log.Printf("First 16 bytes: %x", imgBytes[:min(16, len(imgBytes))])
-
-	// Try generic image.Decode
-	img, format, err := image.Decode(bytes.NewReader(imgBytes))
-	if err == nil {
-		//log.Printf("Decoded using image.Decode, format: %s", format)
-		return img, imgBytes, format, nil
-	}
-	log.Printf("image.Decode failed: %v", err)
-
-	// Skip WebP for now due to CGO dependencies
+	return mimetype
+}
 
-	// Try JPEG
-	img, errJpeg := jpeg.Decode(bytes.NewReader(imgBytes))
-	if errJpeg == nil {
-		log.Printf("Decoded using jpeg.Decode")
-		return img, imgBytes, "jpeg", nil
+// imageFilename returns the file name of an image in the given format
+func imageFilename(name, format string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		name = "image"
 	}
-	log.Printf("jpeg.Decode failed: %v", errJpeg)
+	return name + imageExtensions[format]
+}
 
-	// Try PNG
-	img, errPng := png.Decode(bytes.NewReader(imgBytes))
-	if errPng == nil {
-		log.Printf("Decoded using png.Decode")
-		return img, imgBytes, "png", nil
+// thumbnailFormat returns the format thumbnails of an image are encoded in. We cannot encode
+// WebP, so those get JPEG thumbnails; PNG keeps its transparency.
+func thumbnailFormat(format string) string {
+	if format == "png" {
+		return "png"
 	}
-	log.Printf("png.Decode failed: %v", errPng)
+	return "jpeg"
+}
 
-	// All decoders failed
-	return nil, nil, "", err
+// ThumbnailPreset bounds the size of the thumbnails of one kind of image
+type ThumbnailPreset struct {
+	MaxWidth  int
+	MaxHeight int
 }
 
-func min(a, b int) int {
-	if a < b {
-		return a
+// String returns the preset in the WIDTHxHEIGHT form used in the configuration
+func (p ThumbnailPreset) String() string {
+	return fmt.Sprintf("%dx%d", p.MaxWidth, p.MaxHeight)
+}
+
+// Fits reports whether an image of the given size is within the preset's bounds
+func (p ThumbnailPreset) Fits(width, height int) bool {
+	return width <= p.MaxWidth && height <= p.MaxHeight
+}
+
+// parseThumbnailPreset parses a WIDTHxHEIGHT thumbnail size such as 640x360
+func parseThumbnailPreset(value string) (ThumbnailPreset, error) {
+	var p ThumbnailPreset
+	w, h, ok := strings.Cut(strings.ToLower(strings.TrimSpace(value)), "x")
+	if !ok {
+		return p, fmt.Errorf("%q is not of the form WIDTHxHEIGHT", value)
+	}
+	var err error
+	if p.MaxWidth, err = strconv.Atoi(w); err != nil || p.MaxWidth <= 0 {
+		return p, fmt.Errorf("invalid width in %q", value)
+	}
+	if p.MaxHeight, err = strconv.Atoi(h); err != nil || p.MaxHeight <= 0 {
+		return p, fmt.Errorf("invalid height in %q", value)
 	}
-	return b
+	return p, nil
 }
 
-// generateThumbnail resizes the image to the given width and height
-func generateThumbnail(img image.Image, width, height int) image.Image {
-	return imaging.Resize(img, width, height, imaging.Lanczos)
+// generateThumbnail scales the image down to fit within the preset, keeping its aspect ratio
+func generateThumbnail(img image.Image, preset ThumbnailPreset) image.Image {
+	return imaging.Fit(img, preset.MaxWidth, preset.MaxHeight, imaging.Lanczos)
 }
 
-// encodeImage encodes an image.Image to bytes in the given format
-func encodeImage(img image.Image, format string) ([]byte, error) {
+// encodeImage encodes an image.Image to bytes in the given format; quality applies to JPEG (1-100)
+func encodeImage(img image.Image, format string, quality int) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	switch format {
 	case "jpeg":
-		if err := jpeg.Encode(buf, img, nil); err != nil {
+		if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
 			return nil, err
 		}
 	case "png":
@@ -116,22 +134,46 @@ func calcBlurhash(img image.Image) (string, error) {
 	return blurhash.Encode(4, 3, img)
 }
 
-// uploadToMatrix uploads an image to Matrix and returns the MXC URL and info
-func uploadToMatrix(client *mautrix.Client, filename string, imgBytes []byte, mimetype string, width, height int) (string, *MatrixImageInfo, error) {
+// uploadedMedia is an uploaded image: a plain mxc URL, or an encrypted file for encrypted rooms
+type uploadedMedia struct {
+	URL  string                   `json:"url"`
+	File *event.EncryptedFileInfo `json:"file,omitempty"`
+	Info *MatrixImageInfo         `json:"info"`
+}
+
+// uploadToMatrix uploads an image to Matrix, encrypting it first when the room is encrypted.
+// Nothing is uploaded once ctx is cancelled.
+func uploadToMatrix(ctx context.Context, client *mautrix.Client, filename string, imgBytes []byte, mimetype string, width, height int, encrypt bool) (*uploadedMedia, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	req := mautrix.ReqUploadMedia{
 		ContentBytes: imgBytes,
 		ContentType:  mimetype,
 		FileName:     filename,
 	}
+	var file *event.EncryptedFileInfo
+	if encrypt {
+		file = &event.EncryptedFileInfo{EncryptedFile: *attachment.NewEncryptedFile()}
+		req.ContentBytes = file.Encrypt(imgBytes)
+		req.ContentType = "application/octet-stream"
+	}
 	uploadResp, err := client.UploadMedia(req)
 	if err != nil {
-		return "", nil, err
+		return nil, err
+	}
+	media := &uploadedMedia{
+		URL:  uploadResp.ContentURI.String(),
+		File: file,
+		Info: &MatrixImageInfo{
+			Mimetype: mimetype,
+			Size:     len(imgBytes),
+			W:        width,
+			H:        height,
+		},
 	}
-	info := &MatrixImageInfo{
-		Mimetype: mimetype,
-		Size:     len(imgBytes),
-		W:        width,
-		H:        height,
+	if file != nil {
+		file.URL = uploadResp.ContentURI.CUString()
 	}
-	return uploadResp.ContentURI.String(), info, nil
+	return media, nil
 }
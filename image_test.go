@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"maunium.net/go/mautrix"
+)
+
+// testPNG encodes a solid w×h PNG
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: 200, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecodeWebP(t *testing.T) {
+	data, err := os.ReadFile("testdata/gopher.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decoding WebP: %v", err)
+	}
+	if format != "webp" || img.Bounds().Empty() {
+		t.Fatalf("got format %q, bounds %v", format, img.Bounds())
+	}
+
+	if got := imageMimetype(data); got != "image/webp" {
+		t.Errorf("imageMimetype = %q, want image/webp", got)
+	}
+	if got := imageFilename("Hades II", format); got != "Hades II.webp" {
+		t.Errorf("imageFilename = %q, want Hades II.webp", got)
+	}
+	// WebP can't be encoded, so its thumbnails are JPEG
+	if got := thumbnailFormat(format); got != "jpeg" {
+		t.Errorf("thumbnailFormat = %q, want jpeg", got)
+	}
+}
+
+func TestImageMimetype(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "image/png"},
+		{[]byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "image/jpeg"},
+		{[]byte("GIF89a"), "image/gif"},
+		{[]byte("<html></html>"), "application/octet-stream"},
+	}
+	for _, tc := range tests {
+		if got := imageMimetype(tc.data); got != tc.want {
+			t.Errorf("imageMimetype(%q) = %q, want %q", tc.data, got, tc.want)
+		}
+	}
+}
+
+func TestImageFilename(t *testing.T) {
+	tests := []struct {
+		name, format, want string
+	}{
+		{"Starfield", "jpeg", "Starfield.jpg"},
+		{"Starfield", "png", "Starfield.png"},
+		{"Half-Life 2: Episode One", "gif", "Half-Life 2_ Episode One.gif"},
+		{"AC/DC?\n", "webp", "AC_DC_.webp"},
+		{"  ", "jpeg", "image.jpg"},
+	}
+	for _, tc := range tests {
+		if got := imageFilename(tc.name, tc.format); got != tc.want {
+			t.Errorf("imageFilename(%q, %q) = %q, want %q", tc.name, tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestThumbnailFormat(t *testing.T) {
+	for format, want := range map[string]string{"png": "png", "jpeg": "jpeg", "gif": "jpeg", "webp": "jpeg"} {
+		if got := thumbnailFormat(format); got != want {
+			t.Errorf("thumbnailFormat(%q) = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestParseThumbnailPreset(t *testing.T) {
+	tests := []struct {
+		value string
+		want  ThumbnailPreset
+		err   string
+	}{
+		{"640x360", ThumbnailPreset{640, 360}, ""},
+		{" 320X480 ", ThumbnailPreset{320, 480}, ""},
+		{"640", ThumbnailPreset{}, "WIDTHxHEIGHT"},
+		{"x360", ThumbnailPreset{}, "invalid width"},
+		{"0x360", ThumbnailPreset{}, "invalid width"},
+		{"640x-1", ThumbnailPreset{}, "invalid height"},
+		{"640xabc", ThumbnailPreset{}, "invalid height"},
+	}
+	for _, tc := range tests {
+		got, err := parseThumbnailPreset(tc.value)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("parseThumbnailPreset(%q) error = %v, want %q", tc.value, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("parseThumbnailPreset(%q) = %v, %v; want %v", tc.value, got, err, tc.want)
+		}
+	}
+}
+
+func TestThumbnailPresetFits(t *testing.T) {
+	preset := ThumbnailPreset{MaxWidth: 640, MaxHeight: 360}
+	tests := []struct {
+		w, h int
+		want bool
+	}{
+		{640, 360, true},
+		{320, 100, true},
+		{641, 360, false},
+		{640, 361, false},
+		{1920, 1080, false},
+	}
+	for _, tc := range tests {
+		if got := preset.Fits(tc.w, tc.h); got != tc.want {
+			t.Errorf("Fits(%d, %d) = %v, want %v", tc.w, tc.h, got, tc.want)
+		}
+	}
+}
+
+func TestGenerateThumbnailKeepsAspectRatio(t *testing.T) {
+	preset := ThumbnailPreset{MaxWidth: 320, MaxHeight: 320}
+	tests := []struct {
+		w, h         int
+		wantW, wantH int
+	}{
+		{1280, 720, 320, 180},  // landscape screenshot: width bound
+		{600, 800, 240, 320},   // portrait cover: height bound
+		{1000, 1000, 320, 320}, // square
+	}
+	for _, tc := range tests {
+		thumb := generateThumbnail(image.NewRGBA(image.Rect(0, 0, tc.w, tc.h)), preset)
+		if b := thumb.Bounds(); b.Dx() != tc.wantW || b.Dy() != tc.wantH {
+			t.Errorf("thumbnail of %dx%d is %dx%d, want %dx%d", tc.w, tc.h, b.Dx(), b.Dy(), tc.wantW, tc.wantH)
+		}
+	}
+}
+
+func TestPrepareIGDBImageSkipsSmallThumbnails(t *testing.T) {
+	var uploads int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/small.png"):
+			w.Write(testPNG(t, 100, 50))
+		case strings.HasPrefix(r.URL.Path, "/large.png"):
+			w.Write(testPNG(t, 800, 400))
+		case strings.Contains(r.URL.Path, "/upload"):
+			atomic.AddInt32(&uploads, 1)
+			w.Write([]byte(`{"content_uri":"mxc://example.org/media"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	client, err := mautrix.NewClient(srv.URL, "@bot:example.org", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := &MatrixClient{client: client, roomID: "!room:example.org", jpegQuality: 80, fetcher: newTestFetcher(1<<20, 1<<20, 0)}
+	preset := ThumbnailPreset{MaxWidth: 200, MaxHeight: 200}
+
+	small, err := mc.prepareIGDBImage(context.Background(), srv.URL+"/small.png", "cover", "Portal 2", preset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.Thumb != nil || uploads != 1 {
+		t.Errorf("small image: thumbnail %+v after %d uploads, want only the original uploaded", small.Thumb, uploads)
+	}
+
+	large, err := mc.prepareIGDBImage(context.Background(), srv.URL+"/large.png", "cover", "Portal 2", preset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if large.Thumb == nil || uploads != 3 {
+		t.Fatalf("large image: thumbnail %+v after %d uploads, want original and thumbnail uploaded", large.Thumb, uploads)
+	}
+	if info := large.Thumb.Info; info.W != 200 || info.H != 100 || info.Mimetype != "image/png" {
+		t.Errorf("thumbnail info = %+v, want a 200x100 PNG", info)
+	}
+}
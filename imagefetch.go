@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrImageRejected is returned for images that must not be retried: client errors, wrong
+// content types and images over the size limits
+var ErrImageRejected = errors.New("image rejected")
+
+// ImageFetcher downloads images with a timeout per attempt, limits on size and dimensions,
+// and retries with exponential backoff on server errors
+type ImageFetcher struct {
+	client    *http.Client
+	timeout   time.Duration
+	maxBytes  int64
+	maxPixels int64
+	retries   int
+	backoff   time.Duration
+}
+
+// NewImageFetcher creates a fetcher; maxBytes limits the body and maxPixels the decoded size
+func NewImageFetcher(timeout time.Duration, maxBytes, maxPixels int64, retries int) *ImageFetcher {
+	return &ImageFetcher{
+		client:    &http.Client{},
+		timeout:   timeout,
+		maxBytes:  maxBytes,
+		maxPixels: maxPixels,
+		retries:   retries,
+		backoff:   time.Second,
+	}
+}
+
+// Fetch downloads and decodes an image, returning the image, its bytes and its format
+// ("jpeg", "png", "gif" or "webp")
+func (f *ImageFetcher) Fetch(ctx context.Context, url string) (image.Image, []byte, string, error) {
+	log.Printf("Attempting to download image: %s", url)
+	var data []byte
+	var err error
+	for attempt := 0; ; attempt++ {
+		data, err = f.download(ctx, url)
+		if err == nil || errors.Is(err, ErrImageRejected) || attempt >= f.retries {
+			break
+		}
+		backoff := f.backoff << attempt
+		log.Printf("Failed to download %s (attempt %d/%d), retrying in %s: %v", url, attempt+1, f.retries+1, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, nil, "", ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	// Check the dimensions from the header before decoding allocates the pixels
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to decode %s (%s): %v", url, http.DetectContentType(data), err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > f.maxPixels {
+		return nil, nil, "", fmt.Errorf("%w: %s is %dx%d pixels, over the limit of %d", ErrImageRejected, url, cfg.Width, cfg.Height, f.maxPixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to decode %s: %v", url, err)
+	}
+	return img, data, format, nil
+}
+
+// download makes one attempt at fetching the body of an image URL
+func (f *ImageFetcher) download(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImageRejected, err)
+	}
+	req.Header.Set("Accept", "image/*")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return nil, fmt.Errorf("server error: %s", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: %s returned %s", ErrImageRejected, url, resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		if mediaType != "application/octet-stream" && !strings.HasPrefix(mediaType, "image/") {
+			return nil, fmt.Errorf("%w: %s has content type %s", ErrImageRejected, url, contentType)
+		}
+	}
+	if resp.ContentLength > f.maxBytes {
+		return nil, fmt.Errorf("%w: %s is %d bytes, over the limit of %d", ErrImageRejected, url, resp.ContentLength, f.maxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, f.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > f.maxBytes {
+		return nil, fmt.Errorf("%w: %s is over the limit of %d bytes", ErrImageRejected, url, f.maxBytes)
+	}
+	return data, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestFetcher returns a fetcher with a short timeout and no backoff delay
+func newTestFetcher(maxBytes, maxPixels int64, retries int) *ImageFetcher {
+	f := NewImageFetcher(200*time.Millisecond, maxBytes, maxPixels, retries)
+	f.backoff = time.Millisecond
+	return f
+}
+
+func TestImageFetcherFetch(t *testing.T) {
+	data := testPNG(t, 40, 30)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	img, raw, format, err := newTestFetcher(1<<20, 1<<20, 0).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if format != "png" || !bytes.Equal(raw, data) || img.Bounds().Dx() != 40 || img.Bounds().Dy() != 30 {
+		t.Errorf("got format %q, %d bytes, bounds %v", format, len(raw), img.Bounds())
+	}
+}
+
+func TestImageFetcherTimeout(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	started := time.Now()
+	_, _, _, err := newTestFetcher(1<<20, 1<<20, 1).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Fetch took %s despite the 200ms timeout", elapsed)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("server called %d times, want a retry after the timeout", n)
+	}
+}
+
+func TestImageFetcherMaxBytes(t *testing.T) {
+	data := testPNG(t, 64, 64)
+	for _, declareLength := range []bool{true, false} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			if !declareLength {
+				// Chunked responses have no Content-Length, so the limit applies while reading
+				w.(http.Flusher).Flush()
+			}
+			w.Write(data)
+		}))
+		_, _, _, err := newTestFetcher(int64(len(data)-1), 1<<20, 3).Fetch(context.Background(), srv.URL)
+		srv.Close()
+		if !errors.Is(err, ErrImageRejected) {
+			t.Errorf("declared length %v: got %v, want ErrImageRejected", declareLength, err)
+		}
+	}
+}
+
+func TestImageFetcherContentType(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html>not found</html>"))
+	}))
+	defer srv.Close()
+
+	_, _, _, err := newTestFetcher(1<<20, 1<<20, 3).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrImageRejected) {
+		t.Fatalf("got %v, want ErrImageRejected", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("rejected image fetched %d times, want no retries", n)
+	}
+}
+
+func TestImageFetcherRetriesServerErrors(t *testing.T) {
+	data := testPNG(t, 8, 8)
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	if _, _, _, err := newTestFetcher(1<<20, 1<<20, 2).Fetch(context.Background(), srv.URL); err != nil {
+		t.Fatalf("Fetch after two 503s: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("server called %d times, want 3", n)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	if _, _, _, err := newTestFetcher(1<<20, 1<<20, 1).Fetch(context.Background(), srv.URL); err == nil || errors.Is(err, ErrImageRejected) {
+		t.Errorf("got %v, want a server error after running out of retries", err)
+	}
+}
+
+func TestImageFetcherClientErrorsAreNotRetried(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	_, _, _, err := newTestFetcher(1<<20, 1<<20, 3).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrImageRejected) || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("got %v after %d calls, want one rejected attempt", err, calls)
+	}
+}
+
+func TestImageFetcherMaxPixels(t *testing.T) {
+	data := testPNG(t, 100, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	if _, _, _, err := newTestFetcher(1<<20, 9999, 0).Fetch(context.Background(), srv.URL); !errors.Is(err, ErrImageRejected) {
+		t.Errorf("got %v, want ErrImageRejected for 10000 pixels over a 9999 limit", err)
+	}
+	if _, _, _, err := newTestFetcher(1<<20, 10000, 0).Fetch(context.Background(), srv.URL); err != nil {
+		t.Errorf("image at the pixel limit rejected: %v", err)
+	}
+}
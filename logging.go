@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Log levels accepted by LOG_LEVEL
+const (
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug" // also logs the score breakdown of every IGDB candidate
+)
+
+// debugLogging is set from LOG_LEVEL at startup and on reload
+var debugLogging atomic.Bool
+
+// setLogLevel applies a LOG_LEVEL value
+func setLogLevel(level string) {
+	debugLogging.Store(level == LogLevelDebug)
+}
+
+// debugEnabled reports whether debug messages are logged
+func debugEnabled() bool {
+	return debugLogging.Load()
+}
+
+// debugf logs a message at the debug log level
+func debugf(format string, args ...interface{}) {
+	if debugEnabled() {
+		log.Printf("DEBUG "+format, args...)
+	}
+}
@@ -1,108 +1,161 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/joho/godotenv"
-	"github.com/mmcdole/gofeed"
 )
 
 // Config holds configuration for the application
 type Config struct {
-	RSSURL            string
-	MatrixHomeserver  string
-	MatrixUserID      string
-	MatrixUser        string
-	MatrixPassword    string
-	MatrixAccessToken string
-	MatrixRoomID      string
-	IGDBClientID      string
-	IGDBClientSecret  string
+	JackettURL           string
+	JackettAPIKey        string
+	TorznabCategories    []int
+	Feeds                []*FeedConfig
+	MatrixHomeserver     string
+	MatrixUserID         string
+	MatrixUser           string
+	MatrixPassword       string
+	MatrixAccessToken    string
+	MatrixRoomID         string
+	MatrixDeviceID       string
+	MatrixEncryption     bool
+	MatrixPickleKey      string
+	MatrixRecoveryKey    string
+	MatrixVerbosity      string
+	MatrixScreenshots    int
+	MatrixGallery        bool
+	MatrixTemplates      string
+	CoverThumbnail       ThumbnailPreset
+	ScreenshotThumbnail  ThumbnailPreset
+	JPEGQuality          int
+	ImageTimeout         time.Duration
+	ImageMaxBytes        int64
+	ImageMaxPixels       int64
+	ImageRetries         int
+	Templates            *MessageTemplates
+	Routes               []*RoomRoute
+	MatrixCommands       bool
+	MatrixCommandUsers   []string
+	MatrixCommandLevel   int
+	IGDBClientID         string
+	IGDBClientSecret     string
+	IGDBCacheTTL         time.Duration
+	IGDBNegativeCacheTTL time.Duration
+	OutboxMaxAttempts    int
+	OutboxRetryBackoff   time.Duration
+	DedupeWindow         time.Duration
+	DedupeMode           string
+	LogLevel             string
 }
 
 // RSSProcessor handles RSS feed processing
 type RSSProcessor struct {
-	config       *Config
+	mu           sync.RWMutex
+	config       *Config       // replaced on reload; read it with currentConfig
+	reloaded     chan struct{} // closed when the configuration is replaced
 	client       *http.Client
 	matrixClient *MatrixClient
 	igdbClient   *IGDBClient
 }
 
 // NewRSSProcessor creates a new RSS processor
-func NewRSSProcessor(config *Config) (*RSSProcessor, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
+func NewRSSProcessor(config *Config, db *sql.DB) (*RSSProcessor, error) {
+	rp, err := newLookupProcessor(config, db, false)
+	if err != nil {
+		return nil, err
+	}
 
-	// Initialize Matrix client
-	matrixClient, err := NewMatrixClient(config, ".env")
+	// Initialize Matrix client; tokens obtained by logging in are kept in the database
+	matrixClient, err := NewMatrixClient(config, NewCredentialStore(db))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Matrix client: %v", err)
 	}
 
+	matrixClient.media = NewMediaCache(db)
+	rp.matrixClient = matrixClient
+	return rp, nil
+}
+
+// newLookupProcessor creates an RSS processor without a Matrix client, for commands that
+// read feeds and look up games but don't send anything. With readOnly set, IGDB lookups
+// use the cache and the saved access token but write neither back.
+func newLookupProcessor(config *Config, db *sql.DB, readOnly bool) (*RSSProcessor, error) {
 	// Initialize IGDB client
-	igdbClient, err := NewIGDBClient(config.IGDBClientID, config.IGDBClientSecret)
+	igdbCache := NewIGDBCache(db, config.IGDBCacheTTL, config.IGDBNegativeCacheTTL)
+	creds := NewCredentialStore(db)
+	if readOnly {
+		igdbCache, creds = igdbCache.ReadOnly(), creds.ReadOnly()
+	}
+	igdbClient, err := NewIGDBClient(config.IGDBClientID, config.IGDBClientSecret, igdbCache, creds)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create IGDB client: %v", err)
 	}
 
 	return &RSSProcessor{
-		config:       config,
-		client:       client,
-		matrixClient: matrixClient,
-		igdbClient:   igdbClient,
+		config:     config,
+		reloaded:   make(chan struct{}),
+		client:     &http.Client{Timeout: 30 * time.Second},
+		igdbClient: igdbClient,
 	}, nil
 }
 
-// extractGameName extracts game name from RSS item title
-func (rp *RSSProcessor) extractGameName(title string) string {
-	// Common patterns for game names in torrent titles
-	patterns := []string{
-		`^(.+?)\s*\[.*?\]`,    // Game Name [Release Info]
-		`^(.+?)\s*\(.*?\)`,    // Game Name (Release Info)
-		`^(.+?)\s*-\s*.*`,     // Game Name - Release Info
-		`^(.+?)\s*v?\d+\.\d+`, // Game Name v1.0
-		`^(.+?)\s*PC.*`,       // Game Name PC
-		`^(.+?)\s*REPACK.*`,   // Game Name REPACK
-		`^(.+?)\s*CRACK.*`,    // Game Name CRACK
-	}
-
-	for _, pattern := range patterns {
-		re := regexp.MustCompile(pattern)
-		matches := re.FindStringSubmatch(title)
-		if len(matches) > 1 {
-			gameName := strings.TrimSpace(matches[1])
-			// Clean up common prefixes/suffixes
-			gameName = strings.TrimPrefix(gameName, "[")
-			gameName = strings.TrimSuffix(gameName, "]")
-			gameName = strings.TrimSpace(gameName)
-			return gameName
-		}
+// extractGameName parses an item title using the given title profile
+func (rp *RSSProcessor) extractGameName(title, profile string) *ParsedTitle {
+	parse, ok := titleProfiles[profile]
+	if !ok {
+		parse = ParseReleaseTitle
 	}
+	return parse(title)
+}
 
-	// If no pattern matches, return the original title
-	return strings.TrimSpace(title)
+// fetchFeedItems queries a feed's Torznab endpoint and returns its items
+func (rp *RSSProcessor) fetchFeedItems(ctx context.Context, feed *FeedConfig) ([]*TorznabItem, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	tc := NewTorznabClient(feed.URL, feed.APIKey, rp.client)
+	items, err := tc.Search(ctx, TorznabQuery{Categories: feed.Categories})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Torznab feed: %v", err)
+	}
+	return items, nil
 }
 
-// processRSSFeed processes the RSS feed and sends notifications
-func (rp *RSSProcessor) processRSSFeed(db *sql.DB) error {
-	fp := gofeed.NewParser()
-	feed, err := fp.ParseURL(rp.config.RSSURL)
+// processFeed retries the feed's pending deliveries, then processes new items and sends notifications to its room.
+// It stops between items when ctx is cancelled; unprocessed items are picked up by the next poll.
+func (rp *RSSProcessor) processFeed(ctx context.Context, db *sql.DB, feed *FeedConfig) error {
+	if err := rp.retryPendingReleases(ctx, db, feed); err != nil {
+		log.Printf("[%s] %v", feed.Name, err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	items, err := rp.fetchFeedItems(ctx, feed)
 	if err != nil {
-		return fmt.Errorf("failed to parse RSS feed: %v", err)
+		return err
 	}
 
-	log.Printf("Processing %d items from RSS feed", len(feed.Items))
+	log.Printf("[%s] Processing %d items from Torznab feed", feed.Name, len(items))
 
-	for _, item := range feed.Items {
-		gameName := rp.extractGameName(item.Title)
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		parsed := rp.extractGameName(item.Title, feed.Profile)
 		guid := item.GUID
-		log.Printf("Extracted game name: %s - guid: %s", gameName, guid)
+		log.Printf("[%s] Extracted game name: %s (version: %q, group: %q) - guid: %s (seeders: %d, size: %d)",
+			feed.Name, parsed.Name, parsed.Version, parsed.Group, guid, item.Seeders, item.Size)
 
 		processed, err := isPostProcessed(db, guid)
 		if err != nil {
@@ -114,139 +167,260 @@ func (rp *RSSProcessor) processRSSFeed(db *sql.DB) error {
 			continue
 		}
 
-		// Search IGDB for game information with images
-		igdbInfo, err := rp.igdbClient.SearchGameWithImages(gameName)
+		// Put the release in the outbox first; without a record we would resend it every poll
+		release := NewReleaseFromItem(feed, item)
+		release.SetParsedTitle(parsed)
+		rec, err := rp.queueRelease(db, feed, parsed.Name, release)
 		if err != nil {
-			log.Printf("Failed to get IGDB info for %s: %v", gameName, err)
-			// Send basic notification even without IGDB info
-			message := fmt.Sprintf("🎮 New Game: %s", gameName)
-			if err := rp.matrixClient.SendMessage(message); err != nil {
-				log.Printf("Failed to send Matrix message: %v", err)
-			}
-			markPostProcessed(db, guid)
+			log.Printf("Failed to queue release %s: %v", guid, err)
 			continue
 		}
-
-		// Send detailed notification with game info and images
-		err = rp.matrixClient.SendGameNotificationWithImages(igdbInfo)
-		if err != nil {
-			log.Printf("Failed to send Matrix message: %v", err)
-		} else {
-			log.Printf("Sent Matrix message for: %s", igdbInfo.Title)
+		if muted, err := isGameMuted(db, parsed.Name); err != nil {
+			log.Printf("Failed to check muted games: %v", err)
+		} else if muted {
+			rp.skipRelease(db, rec, "muted")
+			continue
 		}
+		rp.deliverRelease(ctx, db, rec, &outboxPayload{Release: release})
 
 		// Add delay to avoid rate limiting
-		markPostProcessed(db, guid)
-		time.Sleep(2 * time.Second)
+		if err := sleepContext(ctx, 2*time.Second); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// loadConfig loads configuration from environment variables
+// loadConfig loads configuration from environment variables, .env and the config file
 func loadConfig() (*Config, error) {
-	// Load .env file if it exists
-	godotenv.Load()
+	src, err := newConfigSource()
+	if err != nil {
+		return nil, err
+	}
 
 	config := &Config{
-		RSSURL:            getEnv("RSS_URL", ""),
-		MatrixHomeserver:  getEnv("MATRIX_HOMESERVER", ""),
-		MatrixUserID:      getEnv("MATRIX_USER_ID", ""),
-		MatrixUser:        getEnv("MATRIX_USER", ""),
-		MatrixPassword:    getEnv("MATRIX_PASSWORD", ""),
-		MatrixAccessToken: getEnv("MATRIX_ACCESS_TOKEN", ""),
-		MatrixRoomID:      getEnv("MATRIX_ROOM_ID", ""),
-		IGDBClientID:      getEnv("IGDB_CLIENT_ID", ""),
-		IGDBClientSecret:  getEnv("IGDB_CLIENT_SECRET", ""),
+		JackettURL:        src.get("JACKETT_URL", ""),
+		JackettAPIKey:     src.get("JACKETT_API_KEY", ""),
+		MatrixHomeserver:  src.get("MATRIX_HOMESERVER", ""),
+		MatrixUserID:      src.get("MATRIX_USER_ID", ""),
+		MatrixUser:        src.get("MATRIX_USER", ""),
+		MatrixPassword:    src.get("MATRIX_PASSWORD", ""),
+		MatrixAccessToken: src.get("MATRIX_ACCESS_TOKEN", ""),
+		MatrixRoomID:      src.get("MATRIX_ROOM_ID", ""),
+		MatrixDeviceID:    src.get("MATRIX_DEVICE_ID", ""),
+		MatrixPickleKey:   src.get("MATRIX_PICKLE_KEY", ""),
+		MatrixRecoveryKey: src.get("MATRIX_RECOVERY_KEY", ""),
+		MatrixVerbosity:   src.get("MATRIX_VERBOSITY", VerbosityFull),
+		MatrixTemplates:   src.get("MATRIX_TEMPLATES", ""),
+		DedupeMode:        src.get("DEDUPE_MODE", DedupeModeReply),
+		LogLevel:          src.get("LOG_LEVEL", LogLevelInfo),
+		IGDBClientID:      src.get("IGDB_CLIENT_ID", ""),
+		IGDBClientSecret:  src.get("IGDB_CLIENT_SECRET", ""),
 	}
 
-	// Validate required configuration
-	if config.RSSURL == "" {
-		return nil, fmt.Errorf("RSS_URL is required")
+	categories, err := parseIntList(src.get("TORZNAB_CATEGORIES", "4000"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("TORZNAB_CATEGORIES"), err)
+	}
+	config.TorznabCategories = categories
+
+	if config.IGDBCacheTTL, err = time.ParseDuration(src.get("IGDB_CACHE_TTL", "168h")); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("IGDB_CACHE_TTL"), err)
+	}
+	if config.IGDBNegativeCacheTTL, err = time.ParseDuration(src.get("IGDB_NEGATIVE_CACHE_TTL", "6h")); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("IGDB_NEGATIVE_CACHE_TTL"), err)
+	}
+	if config.OutboxMaxAttempts, err = strconv.Atoi(src.get("OUTBOX_MAX_ATTEMPTS", "8")); err != nil || config.OutboxMaxAttempts < 1 {
+		return nil, fmt.Errorf("%s must be a positive number", src.name("OUTBOX_MAX_ATTEMPTS"))
+	}
+	if config.OutboxRetryBackoff, err = time.ParseDuration(src.get("OUTBOX_RETRY_BACKOFF", "1m")); err != nil || config.OutboxRetryBackoff <= 0 {
+		return nil, fmt.Errorf("%s must be a positive duration", src.name("OUTBOX_RETRY_BACKOFF"))
+	}
+	if config.DedupeWindow, err = time.ParseDuration(src.get("DEDUPE_WINDOW", "72h")); err != nil || config.DedupeWindow < 0 {
+		return nil, fmt.Errorf("%s must be a duration, or 0 to disable", src.name("DEDUPE_WINDOW"))
+	}
+	if config.DedupeMode != DedupeModeReply && config.DedupeMode != DedupeModeEdit {
+		return nil, fmt.Errorf("%s must be reply or edit", src.name("DEDUPE_MODE"))
+	}
+	if config.LogLevel != LogLevelInfo && config.LogLevel != LogLevelDebug {
+		return nil, fmt.Errorf("%s must be info or debug", src.name("LOG_LEVEL"))
+	}
+
+	if !validVerbosity(config.MatrixVerbosity) {
+		return nil, fmt.Errorf("%s must be full, compact or minimal", src.name("MATRIX_VERBOSITY"))
+	}
+	if config.MatrixScreenshots, err = parseScreenshotCount(src.get("MATRIX_SCREENSHOTS", "5")); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("MATRIX_SCREENSHOTS"), err)
+	}
+	if config.MatrixGallery, err = strconv.ParseBool(src.get("MATRIX_GALLERY", "false")); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("MATRIX_GALLERY"), err)
+	}
+	if config.CoverThumbnail, err = parseThumbnailPreset(src.get("THUMBNAIL_COVER", "225x300")); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("THUMBNAIL_COVER"), err)
+	}
+	if config.ScreenshotThumbnail, err = parseThumbnailPreset(src.get("THUMBNAIL_SCREENSHOT", "640x360")); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("THUMBNAIL_SCREENSHOT"), err)
 	}
+	if config.JPEGQuality, err = strconv.Atoi(src.get("THUMBNAIL_JPEG_QUALITY", "85")); err != nil || config.JPEGQuality < 1 || config.JPEGQuality > 100 {
+		return nil, fmt.Errorf("%s must be a number between 1 and 100", src.name("THUMBNAIL_JPEG_QUALITY"))
+	}
+	if config.ImageTimeout, err = time.ParseDuration(src.get("IMAGE_TIMEOUT", "30s")); err != nil || config.ImageTimeout <= 0 {
+		return nil, fmt.Errorf("%s must be a positive duration", src.name("IMAGE_TIMEOUT"))
+	}
+	if config.ImageMaxBytes, err = strconv.ParseInt(src.get("IMAGE_MAX_BYTES", "20971520"), 10, 64); err != nil || config.ImageMaxBytes <= 0 {
+		return nil, fmt.Errorf("%s must be a positive number", src.name("IMAGE_MAX_BYTES"))
+	}
+	if config.ImageMaxPixels, err = strconv.ParseInt(src.get("IMAGE_MAX_PIXELS", "50000000"), 10, 64); err != nil || config.ImageMaxPixels <= 0 {
+		return nil, fmt.Errorf("%s must be a positive number", src.name("IMAGE_MAX_PIXELS"))
+	}
+	if config.ImageRetries, err = strconv.Atoi(src.get("IMAGE_RETRIES", "3")); err != nil || config.ImageRetries < 0 {
+		return nil, fmt.Errorf("%s must be zero or a positive number", src.name("IMAGE_RETRIES"))
+	}
+	if config.Templates, err = loadMessageTemplates(config.MatrixTemplates); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("MATRIX_TEMPLATES"), err)
+	}
+	if config.MatrixEncryption, err = strconv.ParseBool(src.get("MATRIX_ENCRYPTION", "false")); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("MATRIX_ENCRYPTION"), err)
+	}
+	if config.MatrixEncryption && config.MatrixPickleKey == "" {
+		return nil, fmt.Errorf("%s is required when %s is enabled", src.name("MATRIX_PICKLE_KEY"), src.name("MATRIX_ENCRYPTION"))
+	}
+	if config.MatrixCommands, err = strconv.ParseBool(src.get("MATRIX_COMMANDS", "true")); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("MATRIX_COMMANDS"), err)
+	}
+	config.MatrixCommandUsers = splitList(src.get("MATRIX_COMMAND_USERS", ""))
+	if config.MatrixCommandLevel, err = strconv.Atoi(src.get("MATRIX_COMMAND_POWER_LEVEL", "50")); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", src.name("MATRIX_COMMAND_POWER_LEVEL"), err)
+	}
+
+	// Validate required configuration
 	if config.MatrixHomeserver == "" {
-		return nil, fmt.Errorf("MATRIX_HOMESERVER is required")
+		return nil, fmt.Errorf("%s is required", src.name("MATRIX_HOMESERVER"))
 	}
 	if config.MatrixUserID == "" {
-		return nil, fmt.Errorf("MATRIX_USER_ID is required")
-	}
-	if config.MatrixRoomID == "" {
-		return nil, fmt.Errorf("MATRIX_ROOM_ID is required")
+		return nil, fmt.Errorf("%s is required", src.name("MATRIX_USER_ID"))
 	}
 	if config.IGDBClientID == "" {
-		return nil, fmt.Errorf("IGDB_CLIENT_ID is required")
+		return nil, fmt.Errorf("%s is required", src.name("IGDB_CLIENT_ID"))
 	}
 	if config.IGDBClientSecret == "" {
-		return nil, fmt.Errorf("IGDB_CLIENT_SECRET is required")
+		return nil, fmt.Errorf("%s is required", src.name("IGDB_CLIENT_SECRET"))
 	}
 
 	// Validate Matrix authentication - either access token or user/pass required
 	if config.MatrixAccessToken == "" && (config.MatrixUser == "" || config.MatrixPassword == "") {
-		return nil, fmt.Errorf("either MATRIX_ACCESS_TOKEN or both MATRIX_USER and MATRIX_PASSWORD are required")
+		return nil, fmt.Errorf("either %s or both %s and %s are required", src.name("MATRIX_ACCESS_TOKEN"), src.name("MATRIX_USER"), src.name("MATRIX_PASSWORD"))
+	}
+
+	feeds, err := loadFeedConfigs(config, src)
+	if err != nil {
+		return nil, err
+	}
+	config.Feeds = feeds
+
+	routes, err := loadRoutes(config, src)
+	if err != nil {
+		return nil, err
 	}
+	config.Routes = routes
 
 	return config, nil
 }
 
-// getEnv gets an environment variable with a default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
 	}
-	return defaultValue
+	return out
 }
 
-// saveConfig saves the configuration to a .env file
-func saveConfig(configPath string, cfg *Config) error {
-	envContent := fmt.Sprintf(`# RSS Feed Configuration
-RSS_URL=%s
-
-# Matrix Configuration
-MATRIX_HOMESERVER=%s
-MATRIX_USER_ID=%s
-MATRIX_USER=%s
-MATRIX_PASSWORD=%s
-MATRIX_ACCESS_TOKEN=%s
-MATRIX_ROOM_ID=%s
-
-# IGDB API Configuration
-IGDB_CLIENT_ID=%s
-IGDB_CLIENT_SECRET=%s
-`, cfg.RSSURL, cfg.MatrixHomeserver, cfg.MatrixUserID, cfg.MatrixUser, cfg.MatrixPassword, cfg.MatrixAccessToken, cfg.MatrixRoomID, cfg.IGDBClientID, cfg.IGDBClientSecret)
-
-	return os.WriteFile(configPath, []byte(envContent), 0644)
+// parseIntList parses a comma-separated list of integers
+func parseIntList(value string) ([]int, error) {
+	var out []int
+	for _, part := range splitList(value) {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", part)
+		}
+		out = append(out, n)
+	}
+	return out, nil
 }
 
-func main() {
-	log.Println("Starting Zamunda RSS Jackett processor...")
+// dbPath is the SQLite database holding processed posts and caches
+const dbPath = "processed_posts.db"
 
-	// Load configuration
-	config, err := loadConfig()
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+func main() {
+	err := runCommand(os.Args[1:])
+	switch {
+	case err == nil || errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		// The usage text was already printed
+		os.Exit(2)
+	default:
+		log.Fatal(err)
 	}
+}
 
-	// Create RSS processor
-	processor, err := NewRSSProcessor(config)
-	if err != nil {
-		log.Fatalf("Failed to create RSS processor: %v", err)
+// runDaemon implements the run command: poll every feed until SIGINT or SIGTERM
+func runDaemon(args []string) error {
+	fs := newFlagSet("run")
+	if err := parseFlags(fs, args); err != nil {
+		return err
 	}
 
-	// Initialize DB
-	db, err := initDB("processed_posts.db")
+	log.Println("Starting Zamunda RSS Jackett processor...")
+
+	processor, db, err := openProcessor(processorPosting)
 	if err != nil {
-		log.Fatalf("Failed to initialize DB: %v", err)
+		return err
 	}
-	log.Println("SQLite DB initialized.")
 	defer db.Close()
+	defer processor.matrixClient.Close()
+	config := processor.currentConfig()
 
-	for {
-		// Process RSS feed
-		if err := processor.processRSSFeed(db); err != nil {
-			log.Fatalf("Failed to process RSS feed: %v", err)
-		}
+	ctx, stop := signalContext()
+	defer stop()
 
-		log.Println("RSS processing completed successfully!")
-		time.Sleep(1 * time.Minute)
+	if err := processor.checkFeedCaps(ctx, config.Feeds); err != nil {
+		return err
 	}
+
+	// Answer commands in the notification room; encryption also needs a running sync for keys
+	var wg sync.WaitGroup
+	commands := config.MatrixCommands && config.MatrixRoomID != ""
+	if commands {
+		bot := NewBotCommands(ctx, processor, db)
+		bot.Register()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bot.Serve()
+		}()
+	}
+	if commands || processor.matrixClient.Encrypted() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processor.matrixClient.Sync(ctx, []string{config.MatrixRoomID})
+		}()
+	}
+
+	// Reload the configuration on SIGHUP or when the files change
+	go processor.watchConfig(ctx)
+
+	// Poll every feed on its own schedule
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		processor.runFeeds(ctx, db)
+	}()
+	wg.Wait()
+	log.Println("Stopped.")
+	return nil
 }
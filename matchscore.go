@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Henry-Sarabia/igdb/v2"
+)
+
+// MatchScorer scores how well an IGDB game matches the name extracted from a release.
+// query is lower case and trimmed; the candidate with the highest total is selected.
+type MatchScorer interface {
+	Score(query string, game *igdb.Game) ScoreBreakdown
+}
+
+// ScoreBreakdown is a match score and how it was reached:
+// Total = min(1, (Base + Recency + Category) * AgePenalty * WordPenalty)
+type ScoreBreakdown struct {
+	Base        float64 `json:"base"`                   // name similarity, 0 when the names don't match at all
+	Recency     float64 `json:"recency"`                // bonus for recent and upcoming games
+	Category    float64 `json:"category"`               // bonus for main games over DLC, bundles and the like
+	AgePenalty  float64 `json:"age_penalty"`            // factor for games released before 2010, 1 for none
+	PenaltyWord string  `json:"penalty_word,omitempty"` // pack or collection word found in the name
+	WordPenalty float64 `json:"word_penalty"`           // factor for PenaltyWord, 1 for none
+	Total       float64 `json:"total"`
+}
+
+// String formats the breakdown for logs
+func (b ScoreBreakdown) String() string {
+	s := fmt.Sprintf("%.3f = base %.3f + recency %.3f + category %.3f", b.Total, b.Base, b.Recency, b.Category)
+	if b.AgePenalty != 1 {
+		s += fmt.Sprintf(", old game x%.2f", b.AgePenalty)
+	}
+	if b.PenaltyWord != "" {
+		s += fmt.Sprintf(", %q x%.2f", b.PenaltyWord, b.WordPenalty)
+	}
+	return s
+}
+
+// MatchCandidate is a game found by an IGDB search and how it scored
+type MatchCandidate struct {
+	Game      *igdb.Game
+	Breakdown ScoreBreakdown
+}
+
+// rankCandidates scores every game against the query and returns them best first; ties keep
+// the order of games, which searchGames sorts newest first
+func rankCandidates(scorer MatchScorer, query string, games []*igdb.Game) []MatchCandidate {
+	searchLower := strings.ToLower(strings.TrimSpace(query))
+	candidates := make([]MatchCandidate, 0, len(games))
+	for _, game := range games {
+		candidates = append(candidates, MatchCandidate{Game: game, Breakdown: scorer.Score(searchLower, game)})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Breakdown.Total > candidates[j].Breakdown.Total
+	})
+	return candidates
+}
+
+// logMatchCandidates logs the selected candidate, and every candidate at the debug log level
+func logMatchCandidates(query string, candidates []MatchCandidate) {
+	if debugEnabled() {
+		debugf("IGDB candidates for '%s':", query)
+		for i, c := range candidates {
+			debugf("  %d. '%s' (ID %d, %s, %s): %s", i+1, c.Game.Name, c.Game.ID,
+				formatReleaseDate(int64(c.Game.FirstReleaseDate)), c.Game.Category, c.Breakdown)
+		}
+	}
+	best := candidates[0]
+	log.Printf("=== SELECTED: '%s' (score %s) ===", best.Game.Name, best.Breakdown)
+}
+
+// penaltyWords mark packs, collections and special editions, which are rarely what a release is
+var penaltyWords = []string{
+	"pack", "collection", "bundle", "double", "triple", "quadruple",
+	"complete", "ultimate", "deluxe", "edition", "remastered",
+	"remaster", "definitive", "anniversary", "gold", "platinum",
+	"+", "plus", "and", "&", "with", "featuring", "including",
+}
+
+// defaultMatchScorer prefers exact and prefix name matches of recent main games
+type defaultMatchScorer struct{}
+
+// Score implements MatchScorer
+func (defaultMatchScorer) Score(query string, game *igdb.Game) ScoreBreakdown {
+	gameName := strings.ToLower(strings.TrimSpace(game.Name))
+	b := ScoreBreakdown{AgePenalty: 1, WordPenalty: 1}
+
+	switch {
+	case gameName == query:
+		// Perfect exact match
+		b.Base = 1.0
+	case strings.HasPrefix(gameName, query):
+		b.Base = 0.9
+	case strings.Contains(gameName, query):
+		b.Base = 0.8
+	case strings.Contains(query, gameName):
+		// Game name is contained in the search query
+		b.Base = 0.7
+	default:
+		b.Base = wordMatchScore(query, gameName)
+	}
+
+	// Names that don't match get no bonuses
+	if b.Base == 0 {
+		return b
+	}
+
+	// Recency bonus (0.0 to 0.2 for recent games)
+	b.Recency = calculateRecencyBonus(game.FirstReleaseDate)
+
+	// Bonus for main games (not DLC, updates, etc.)
+	if game.Category == igdb.MainGame {
+		b.Category = 0.1
+	}
+
+	// Heavy penalty for very old games (pre-2010)
+	if game.FirstReleaseDate != 0 && time.Unix(int64(game.FirstReleaseDate), 0).Year() < 2010 {
+		b.AgePenalty = 0.5
+	}
+
+	// Heavy penalty for game packs, collections, and similar titles
+	for _, word := range penaltyWords {
+		if strings.Contains(gameName, word) {
+			b.PenaltyWord = word
+			b.WordPenalty = 0.3
+			break
+		}
+	}
+
+	b.Total = (b.Base + b.Recency + b.Category) * b.AgePenalty * b.WordPenalty
+	if b.Total > 1.0 {
+		b.Total = 1.0
+	}
+	return b
+}
+
+// wordMatchScore scores names that share words: up to 0.6 when more than half of the
+// query's words appear in the game name, otherwise 0
+func wordMatchScore(query, gameName string) float64 {
+	searchWords := strings.Fields(query)
+	if len(searchWords) == 0 {
+		return 0
+	}
+	gameWords := strings.Fields(gameName)
+
+	wordMatches := 0
+	for _, searchWord := range searchWords {
+		for _, gameWord := range gameWords {
+			if searchWord == gameWord {
+				wordMatches++
+				break
+			}
+		}
+	}
+
+	wordScore := float64(wordMatches) / float64(len(searchWords))
+	if wordScore <= 0.5 {
+		return 0
+	}
+	return wordScore * 0.6 // Cap at 0.6 for partial word matches
+}
+
+// calculateRecencyBonus returns a bonus score (0.0 to 0.2) based on how recent the game is
+func calculateRecencyBonus(releaseDate int) float64 {
+	if releaseDate == 0 {
+		return 0.0 // No release date, no bonus
+	}
+
+	// Convert Unix timestamp to time
+	releaseTime := time.Unix(int64(releaseDate), 0)
+	now := time.Now()
+
+	// Calculate years difference (positive for past, negative for future)
+	yearsDifference := releaseTime.Sub(now).Hours() / (24 * 365.25)
+
+	// Handle future release dates (upcoming games)
+	if yearsDifference > 0 {
+		// Future games get maximum bonus if releasing within 1 year
+		if yearsDifference <= 1 {
+			return 0.2 // Maximum bonus for games releasing soon
+		} else if yearsDifference <= 2 {
+			// Decreasing bonus for games releasing in 1-2 years
+			return 0.2 - (yearsDifference-1)*0.1
+		} else {
+			// Very distant future games get minimal bonus
+			return 0.05
+		}
+	}
+
+	// Handle past release dates (released games)
+	yearsSinceRelease := -yearsDifference // Convert to positive number
+
+	// Give maximum bonus (0.2) for games released in the last 2 years
+	// Gradually decrease bonus for older games
+	if yearsSinceRelease <= 2 {
+		return 0.2
+	} else if yearsSinceRelease <= 5 {
+		// Linear decrease from 0.2 to 0.1 over 3 years
+		return 0.2 - (yearsSinceRelease-2)*0.033
+	} else if yearsSinceRelease <= 10 {
+		// Linear decrease from 0.1 to 0.05 over 5 years
+		return 0.1 - (yearsSinceRelease-5)*0.01
+	} else {
+		// Very old games get minimal bonus
+		return 0.05
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Henry-Sarabia/igdb/v2"
+)
+
+// yearsAgo returns an IGDB release date the given number of years before now
+func yearsAgo(years float64) int {
+	return int(time.Now().Add(-time.Duration(years * 365.25 * 24 * float64(time.Hour))).Unix())
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestDefaultMatchScorer(t *testing.T) {
+	recent := yearsAgo(1)
+	cases := []struct {
+		name  string
+		query string
+		game  igdb.Game
+		want  ScoreBreakdown
+	}{
+		{"exact recent main game", "baldur's gate 3", igdb.Game{Name: "Baldur's Gate 3", FirstReleaseDate: recent, Category: igdb.MainGame},
+			ScoreBreakdown{Base: 1, Recency: 0.2, Category: 0.1, AgePenalty: 1, WordPenalty: 1, Total: 1}},
+		{"prefix DLC", "portal", igdb.Game{Name: "Portal Stories", FirstReleaseDate: recent, Category: igdb.DLCAddon},
+			ScoreBreakdown{Base: 0.9, Recency: 0.2, AgePenalty: 1, WordPenalty: 1, Total: 1}},
+		{"contains, no date", "gate", igdb.Game{Name: "Baldur's Gate", Category: igdb.MainGame},
+			ScoreBreakdown{Base: 0.8, Category: 0.1, AgePenalty: 1, WordPenalty: 1, Total: 0.9}},
+		{"query contains the name", "starfield shattered space", igdb.Game{Name: "Starfield", Category: igdb.DLCAddon},
+			ScoreBreakdown{Base: 0.7, AgePenalty: 1, WordPenalty: 1, Total: 0.7}},
+		{"shared words", "witcher 3 wild hunt", igdb.Game{Name: "The Witcher 3: Wild Hunt", Category: igdb.DLCAddon},
+			ScoreBreakdown{Base: 0.45, AgePenalty: 1, WordPenalty: 1, Total: 0.45}},
+		{"too few shared words", "half life alyx", igdb.Game{Name: "Half-Life 2", FirstReleaseDate: recent},
+			ScoreBreakdown{AgePenalty: 1, WordPenalty: 1}},
+		{"old game", "half-life 2", igdb.Game{Name: "Half-Life 2", FirstReleaseDate: int(time.Date(2004, 11, 16, 0, 0, 0, 0, time.UTC).Unix()), Category: igdb.MainGame},
+			ScoreBreakdown{Base: 1, Recency: 0.05, Category: 0.1, AgePenalty: 0.5, WordPenalty: 1, Total: 0.575}},
+		{"pack", "doom", igdb.Game{Name: "DOOM Collection", Category: igdb.MainGame},
+			ScoreBreakdown{Base: 0.9, Category: 0.1, AgePenalty: 1, PenaltyWord: "collection", WordPenalty: 0.3, Total: 0.3}},
+	}
+	for _, tc := range cases {
+		got := defaultMatchScorer{}.Score(tc.query, &tc.game)
+		if !approxEqual(got.Base, tc.want.Base) || !approxEqual(got.Recency, tc.want.Recency) || !approxEqual(got.Category, tc.want.Category) ||
+			got.AgePenalty != tc.want.AgePenalty || got.PenaltyWord != tc.want.PenaltyWord || got.WordPenalty != tc.want.WordPenalty ||
+			!approxEqual(got.Total, tc.want.Total) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCalculateRecencyBonus(t *testing.T) {
+	cases := []struct {
+		date int
+		want float64
+	}{
+		{0, 0},
+		{yearsAgo(-0.5), 0.2},
+		{yearsAgo(-1.5), 0.15},
+		{yearsAgo(-3), 0.05},
+		{yearsAgo(1), 0.2},
+		{yearsAgo(3), 0.167},
+		{yearsAgo(7), 0.08},
+		{yearsAgo(15), 0.05},
+	}
+	for _, tc := range cases {
+		if got := calculateRecencyBonus(tc.date); math.Abs(got-tc.want) > 0.001 {
+			t.Errorf("calculateRecencyBonus(%s) = %.3f, want %.3f", time.Unix(int64(tc.date), 0).Format("2006-01-02"), got, tc.want)
+		}
+	}
+}
+
+func TestRankCandidates(t *testing.T) {
+	games := []*igdb.Game{
+		{ID: 1, Name: "Starfield Collection", Category: igdb.MainGame},
+		{ID: 2, Name: "Starfield", Category: igdb.MainGame},
+		{ID: 3, Name: "Starfield", Category: igdb.MainGame},
+		{ID: 4, Name: "Unrelated", Category: igdb.MainGame},
+	}
+	candidates := rankCandidates(defaultMatchScorer{}, "  Starfield ", games)
+	var ids []int
+	for _, c := range candidates {
+		ids = append(ids, c.Game.ID)
+	}
+	// Equal scores keep the search order
+	if want := []int{2, 3, 1, 4}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("ranked IDs = %v, want %v", ids, want)
+	}
+}
@@ -1,94 +1,243 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"image"
+	"io"
 	"log"
 	"time"
 
 	"maunium.net/go/mautrix"
 	"maunium.net/go/mautrix/event"
-	mautrixEvent "maunium.net/go/mautrix/event"
 	mautrixID "maunium.net/go/mautrix/id"
 )
 
+// matrixRequestTimeout bounds every request to the homeserver, including the 30s sync long-poll.
+// The mautrix client cannot cancel a request in flight, so this is how long shutdown may wait on one.
+const matrixRequestTimeout = 90 * time.Second
+
 // MatrixClient handles Matrix operations
 type MatrixClient struct {
 	client *mautrix.Client
 	roomID mautrixID.RoomID
+
+	coverThumbnail      ThumbnailPreset
+	screenshotThumbnail ThumbnailPreset
+	jpegQuality         int
+	media               *MediaCache
+	fetcher             *ImageFetcher
 }
 
-// NewMatrixClient creates a new Matrix client
-func NewMatrixClient(cfg *Config, configPath string) (*MatrixClient, error) {
-	var client *mautrix.Client
-	var err error
+// NewMatrixClient creates a new Matrix client. Without a working access token it logs in with the
+// password and keeps the new token and device ID in creds for the next start.
+func NewMatrixClient(cfg *Config, creds *CredentialStore) (*MatrixClient, error) {
+	savedToken, _, err := creds.Get(credentialMatrixToken + cfg.MatrixUserID)
+	if err != nil {
+		log.Printf("Failed to read saved Matrix access token: %v", err)
+	}
+	savedDevice, _, err := creds.Get(credentialMatrixDevice + cfg.MatrixUserID)
+	if err != nil {
+		log.Printf("Failed to read saved Matrix device ID: %v", err)
+	}
 
-	// First, try to use existing access token if available
-	if cfg.MatrixAccessToken != "" {
-		client, err = mautrix.NewClient(cfg.MatrixHomeserver, mautrixID.UserID(cfg.MatrixUserID), cfg.MatrixAccessToken)
+	// First, try the configured access token, then the one saved by the last password login
+	var client *mautrix.Client
+	for i, token := range []string{cfg.MatrixAccessToken, savedToken} {
+		if token == "" || (i == 1 && token == cfg.MatrixAccessToken) {
+			continue
+		}
+		candidate, err := mautrix.NewClient(cfg.MatrixHomeserver, mautrixID.UserID(cfg.MatrixUserID), token)
 		if err != nil {
 			return nil, err
 		}
+		candidate.Client.Timeout = matrixRequestTimeout
 
 		// Test if the token is still valid by making a simple API call
-		_, err = client.Whoami()
+		whoami, err := candidate.Whoami()
 		if err != nil {
-			log.Printf("Access token is invalid, attempting to refresh: %v", err)
-			// Token is invalid, we'll need to refresh it
-			client = nil
-		} else {
-			// Token is valid, return the client
-			return &MatrixClient{
-				client: client,
-				roomID: mautrixID.RoomID(cfg.MatrixRoomID),
-			}, nil
+			log.Printf("Access token is invalid: %v", err)
+			continue
 		}
+		candidate.DeviceID = whoami.DeviceID
+		client = candidate
+		break
 	}
 
-	// If we reach here, either no token was provided or the existing token is invalid
+	// If we reach here without a client, either no token was provided or the tokens are invalid
 	// Try to get a new token using username/password
-	if cfg.MatrixUser != "" && cfg.MatrixPassword != "" {
+	if client == nil {
+		if cfg.MatrixUser == "" || cfg.MatrixPassword == "" {
+			return nil, fmt.Errorf("no valid Matrix access token and no user/pass provided")
+		}
 		client, err = mautrix.NewClient(cfg.MatrixHomeserver, mautrixID.UserID(cfg.MatrixUserID), "")
 		if err != nil {
 			return nil, err
 		}
+		client.Client.Timeout = matrixRequestTimeout
+		// Reuse the previous device so its encryption keys stay valid
+		deviceID := cfg.MatrixDeviceID
+		if deviceID == "" {
+			deviceID = savedDevice
+		}
 		resp, err := client.Login(&mautrix.ReqLogin{
-			Type:       "m.login.password",
-			Identifier: mautrix.UserIdentifier{User: cfg.MatrixUser},
-			Password:   cfg.MatrixPassword,
+			Type:             "m.login.password",
+			Identifier:       mautrix.UserIdentifier{User: cfg.MatrixUser},
+			Password:         cfg.MatrixPassword,
+			DeviceID:         mautrixID.DeviceID(deviceID),
+			StoreCredentials: true,
 		})
 		if err != nil {
 			return nil, err
 		}
-		cfg.MatrixAccessToken = resp.AccessToken
-		saveErr := saveConfig(configPath, cfg)
+		saveErr := creds.Set(credentialMatrixToken+cfg.MatrixUserID, resp.AccessToken, time.Time{})
+		if saveErr == nil {
+			saveErr = creds.Set(credentialMatrixDevice+cfg.MatrixUserID, resp.DeviceID.String(), time.Time{})
+		}
 		if saveErr != nil {
-			log.Printf("Warning: failed to save new access token to config: %v", saveErr)
+			log.Printf("Warning: failed to save new access token: %v", saveErr)
 		} else {
-			log.Printf("Successfully refreshed Matrix access token and saved to config")
+			log.Printf("Logged in to Matrix as device %s and saved the access token in the database", resp.DeviceID)
 		}
-	} else {
-		return nil, fmt.Errorf("no Matrix access token or user/pass provided")
 	}
 
-	return &MatrixClient{
-		client: client,
-		roomID: mautrixID.RoomID(cfg.MatrixRoomID),
-	}, nil
+	mc := &MatrixClient{
+		client:              client,
+		roomID:              mautrixID.RoomID(cfg.MatrixRoomID),
+		coverThumbnail:      cfg.CoverThumbnail,
+		screenshotThumbnail: cfg.ScreenshotThumbnail,
+		jpegQuality:         cfg.JPEGQuality,
+		fetcher:             NewImageFetcher(cfg.ImageTimeout, cfg.ImageMaxBytes, cfg.ImageMaxPixels, cfg.ImageRetries),
+	}
+	if cfg.MatrixEncryption {
+		if err := setupEncryption(mc, cfg); err != nil {
+			return nil, fmt.Errorf("failed to set up encryption: %w", err)
+		}
+	}
+	return mc, nil
+}
+
+// Encrypted reports whether end-to-end encryption is enabled for this client
+func (mc *MatrixClient) Encrypted() bool {
+	return mc.client.Crypto != nil
+}
+
+// roomEncrypted reports whether messages to the client's room must be encrypted
+func (mc *MatrixClient) roomEncrypted() bool {
+	return mc.client.Crypto != nil && mc.client.StateStore != nil && mc.client.StateStore.IsEncrypted(mc.roomID)
+}
+
+// PreloadRooms loads the encryption state and members of encrypted rooms, so that messages to
+// them are encrypted before the sync has seen the rooms. It does nothing without encryption.
+func (mc *MatrixClient) PreloadRooms(roomIDs []string) {
+	if !mc.Encrypted() {
+		return
+	}
+	for _, roomID := range roomIDs {
+		var encryption event.EncryptionEventContent
+		if err := mc.client.StateEvent(mautrixID.RoomID(roomID), event.StateEncryption, "", &encryption); err != nil {
+			continue // not encrypted
+		}
+		if _, err := mc.client.Members(mautrixID.RoomID(roomID)); err != nil {
+			log.Printf("Failed to load members of encrypted room %s: %v", roomID, err)
+		}
+	}
+}
+
+// Close releases the crypto store, if any
+func (mc *MatrixClient) Close() error {
+	if closer, ok := mc.client.Crypto.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Sync receives events until ctx is cancelled, restarting after fatal sync errors. Handlers
+// must be registered on the syncer first. Without encryption only the given rooms' messages
+// are synced; with it the full sync is needed for keys and device lists.
+func (mc *MatrixClient) Sync(ctx context.Context, roomIDs []string) {
+	syncer, ok := mc.client.Syncer.(*mautrix.DefaultSyncer)
+	if !ok {
+		log.Printf("Matrix sync disabled: unsupported syncer %T", mc.client.Syncer)
+		return
+	}
+	if !mc.Encrypted() {
+		var rooms []mautrixID.RoomID
+		for _, roomID := range roomIDs {
+			rooms = append(rooms, mautrixID.RoomID(roomID))
+		}
+		nothing := mautrix.FilterPart{NotTypes: []event.Type{{Type: "*"}}}
+		syncer.FilterJSON = &mautrix.Filter{
+			AccountData: nothing,
+			Presence:    nothing,
+			Room: mautrix.RoomFilter{
+				Rooms:       rooms,
+				AccountData: nothing,
+				Ephemeral:   nothing,
+				State:       nothing,
+				Timeline:    mautrix.FilterPart{Types: []event.Type{event.EventMessage}, Limit: 50},
+			},
+		}
+	}
+
+	for {
+		err := mc.client.SyncWithContext(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Matrix sync failed, restarting in 30s: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(30 * time.Second):
+		}
+	}
+}
+
+// ForRoom returns a copy of the client that sends to the given room
+func (mc *MatrixClient) ForRoom(roomID string) *MatrixClient {
+	if roomID == "" || mautrixID.RoomID(roomID) == mc.roomID {
+		return mc
+	}
+	room := *mc
+	room.roomID = mautrixID.RoomID(roomID)
+	return &room
+}
+
+// RedactEvents redacts previously sent events in the room, logging failures
+func (mc *MatrixClient) RedactEvents(eventIDs []string, reason string) {
+	for _, eventID := range eventIDs {
+		if _, err := mc.client.RedactEvent(mc.roomID, mautrixID.EventID(eventID), mautrix.ReqRedact{Reason: reason}); err != nil {
+			log.Printf("Failed to redact %s: %v", eventID, err)
+		}
+	}
+}
+
+// sendEvent sends a message event to the room unless ctx is already cancelled
+func (mc *MatrixClient) sendEvent(ctx context.Context, content interface{}) (mautrixID.EventID, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	resp, err := mc.client.SendMessageEvent(mc.roomID, event.EventMessage, content)
+	if err != nil {
+		return "", err
+	}
+	return resp.EventID, nil
 }
 
-// SendMessage sends a text message to the configured room
-func (mc *MatrixClient) SendMessage(message string) error {
-	_, err := mc.client.SendText(mc.roomID, message)
+// SendMessage sends a text message to the configured room and returns its event ID
+func (mc *MatrixClient) SendMessage(ctx context.Context, message string) (mautrixID.EventID, error) {
+	eventID, err := mc.sendEvent(ctx, &event.MessageEventContent{MsgType: event.MsgText, Body: message})
 	if err != nil {
 		log.Printf("Failed to send Matrix message: %v", err)
-		return err
+		return "", err
 	}
 	log.Printf("Successfully sent Matrix message")
-	return nil
+	return eventID, nil
 }
 
-// SendFormattedMessage sends a formatted message with HTML content
-func (mc *MatrixClient) SendFormattedMessage(text, html string) error {
+// SendFormattedMessage sends a formatted message with HTML content and returns its event ID
+func (mc *MatrixClient) SendFormattedMessage(ctx context.Context, text, html string) (mautrixID.EventID, error) {
 	content := &event.MessageEventContent{
 		MsgType:       event.MsgText,
 		Body:          text,
@@ -96,104 +245,227 @@ func (mc *MatrixClient) SendFormattedMessage(text, html string) error {
 		FormattedBody: html,
 	}
 
-	_, err := mc.client.SendMessageEvent(mc.roomID, event.EventMessage, content)
+	eventID, err := mc.sendEvent(ctx, content)
 	if err != nil {
 		log.Printf("Failed to send formatted Matrix message: %v", err)
-		return err
+		return "", err
 	}
 	log.Printf("Successfully sent formatted Matrix message")
-	return nil
+	return eventID, nil
 }
 
-// SendGameNotification sends a formatted game notification
-func (mc *MatrixClient) SendGameNotification(gameName, releaseDate, rating, genres, platforms, summary, downloadLink string) error {
-	// Create plain text version
-	textMessage := formatGameMessageText(gameName, releaseDate, rating, genres, platforms, summary, downloadLink)
-
-	// Create HTML version
-	htmlMessage := formatGameMessageHTML(gameName, releaseDate, rating, genres, platforms, summary, downloadLink)
-
-	return mc.SendFormattedMessage(textMessage, htmlMessage)
+// SendReleaseNotification sends a basic notification for a release without IGDB info
+func (mc *MatrixClient) SendReleaseNotification(ctx context.Context, gameName string, release *Release, route *RoomRoute) (mautrixID.EventID, error) {
+	textMessage, htmlMessage, err := route.messageTemplates().Render(templateRelease, &MessageData{
+		Name:      gameName,
+		Release:   release,
+		Verbosity: route.verbosity(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return mc.SendFormattedMessage(ctx, textMessage, htmlMessage)
 }
 
-// SendGameNotificationWithImages sends a game notification with cover image and screenshots in a thread
-func (mc *MatrixClient) SendGameNotificationWithImages(gameInfo *IGDBGameInfo) error {
-	// Create plain text version
-	textMessage := formatGameMessageText(gameInfo.Title, formatReleaseDate(gameInfo.Date), "0", "Unknown", "Unknown", gameInfo.Summary, "")
-
-	// Create HTML version
-	htmlMessage := formatGameMessageHTML(gameInfo.Title, formatReleaseDate(gameInfo.Date), "0", "Unknown", "Unknown", gameInfo.Summary, "")
-
-	var threadRootID mautrixID.EventID
-	var replyID mautrixID.EventID
+// SendGameNotificationWithImages sends a game notification with cover image and screenshots in a thread,
+// using the verbosity, screenshot count and gallery setting of the route (or the defaults when route is nil).
+// The thread hangs off the cover image, or off the text message when the game has no cover.
+// It returns the IDs of the events that were sent. Once the notification is sent, cancelling ctx only skips
+// the remaining screenshots.
+func (mc *MatrixClient) SendGameNotificationWithImages(ctx context.Context, gameInfo *IGDBGameInfo, release *Release, route *RoomRoute) ([]mautrixID.EventID, error) {
+	maxScreenshots, gallery := 5, false
+	if route != nil {
+		maxScreenshots, gallery = route.Screenshots, route.Gallery
+	}
+	textMessage, htmlMessage, err := renderGameMessage(gameInfo, release, route)
+	if err != nil {
+		return nil, err
+	}
 
-	// Send cover image as the main message if available
+	// Send cover image as the main message if available, falling back to text
+	var rootID mautrixID.EventID
 	if gameInfo.CoverURL != "" {
-		eventID, err := mc.postIGDBImageToMatrix(gameInfo.CoverURL, textMessage, htmlMessage, "", "")
+		eventID, err := mc.postIGDBImageToMatrix(ctx, gameInfo.CoverURL, textMessage, htmlMessage, gameInfo.Title+" cover", mc.coverThumbnail, "", "")
 		if err != nil {
 			log.Printf("Failed to send cover image: %v", err)
-			// Fallback to text message
-			return mc.SendFormattedMessage(textMessage, htmlMessage)
 		}
-		threadRootID = eventID
-		replyID = eventID
-	} else {
-		// No cover image, send text message
-		err := mc.SendFormattedMessage(textMessage, htmlMessage)
+		rootID = eventID
+	}
+	if rootID == "" {
+		// A cover that failed because of shutdown is no reason to post the text alone
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		eventID, err := mc.SendFormattedMessage(ctx, textMessage, htmlMessage)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		// We'll need to get the event ID from the text message to create a thread
-		// For now, we'll skip screenshots if no cover image
-		return nil
+		rootID = eventID
 	}
+	eventIDs := []mautrixID.EventID{rootID}
 
-	// Send screenshots in the thread
-	if len(gameInfo.Screenshots) > 0 {
-		for i, screenshotURL := range gameInfo.Screenshots {
-			// Limit to first 5 screenshots to avoid spam
-			if i >= 5 {
-				break
-			}
+	// Limit screenshots to avoid spam
+	screenshots := gameInfo.Screenshots
+	if len(screenshots) > maxScreenshots {
+		screenshots = screenshots[:maxScreenshots]
+	}
+	var images []*preparedImage
+	for i, screenshotURL := range screenshots {
+		caption := fmt.Sprintf("Screenshot %d of %s", i+1, gameInfo.Title)
+		if ctx.Err() != nil {
+			log.Printf("Skipping remaining screenshots of %s: %v", gameInfo.Title, ctx.Err())
+			return eventIDs, nil
+		}
+		img, err := mc.prepareIGDBImage(ctx, screenshotURL, caption, fmt.Sprintf("%s screenshot %d", gameInfo.Title, i+1), mc.screenshotThumbnail)
+		if err != nil {
+			log.Printf("Failed to prepare screenshot %d: %v", i+1, err)
+			continue
+		}
+		images = append(images, img)
+	}
 
-			caption := fmt.Sprintf("Screenshot %d of %s", i+1, gameInfo.Title)
-			_, err := mc.postIGDBImageToMatrix(screenshotURL, caption, "", threadRootID, replyID)
-			if err != nil {
-				log.Printf("Failed to send screenshot %d: %v", i+1, err)
-			}
+	// Send screenshots in the thread, as one gallery event if the room wants it
+	if gallery && len(images) > 1 {
+		eventID, err := mc.sendMatrixGallery(ctx, "Screenshots of "+gameInfo.Title, images, rootID, rootID)
+		if err == nil {
+			return append(eventIDs, eventID), nil
+		}
+		log.Printf("Failed to send screenshot gallery, sending screenshots one by one: %v", err)
+	}
+	for i, img := range images {
+		if ctx.Err() != nil {
+			log.Printf("Skipping remaining screenshots of %s: %v", gameInfo.Title, ctx.Err())
+			break
+		}
+		eventID, err := mc.sendMatrixImage(ctx, img.Caption, img.Filename, img.Image, img.Thumb, img.Blurhash, rootID, rootID)
+		if err != nil {
+			log.Printf("Failed to send screenshot %d: %v", i+1, err)
+		} else {
+			eventIDs = append(eventIDs, eventID)
+		}
+
+		// Small delay between screenshots
+		sleepContext(ctx, 500*time.Millisecond)
+	}
+
+	return eventIDs, nil
+}
+
+// SendReleaseUpdate posts a newer release of a game in the thread of its earlier notification
+func (mc *MatrixClient) SendReleaseUpdate(ctx context.Context, rootID mautrixID.EventID, gameInfo *IGDBGameInfo, release *Release, route *RoomRoute) (mautrixID.EventID, error) {
+	textMessage, htmlMessage, err := route.messageTemplates().Render(templateUpdate, &MessageData{
+		Name:      gameInfo.Title,
+		Game:      gameInfo,
+		Release:   release,
+		Verbosity: route.verbosity(),
+	})
+	if err != nil {
+		return "", err
+	}
+	content := map[string]interface{}{
+		"msgtype":        "m.text",
+		"body":           textMessage,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": htmlMessage,
+	}
+	if err := setRelation(content, rootID, rootID); err != nil {
+		return "", err
+	}
+	return mc.sendEvent(ctx, content)
+}
+
+// EditGameNotification edits an earlier game notification, text or image, so that it shows a newer release.
+// It returns the ID of the edit event.
+func (mc *MatrixClient) EditGameNotification(ctx context.Context, eventID mautrixID.EventID, gameInfo *IGDBGameInfo, release *Release, route *RoomRoute) (mautrixID.EventID, error) {
+	original, err := mc.eventContent(eventID)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %v", eventID, err)
+	}
 
-			// Small delay between screenshots
-			time.Sleep(500 * time.Millisecond)
+	textMessage, htmlMessage, err := renderGameMessage(gameInfo, release, route)
+	if err != nil {
+		return "", err
+	}
+
+	// The new content keeps the original's media and swaps the text
+	newContent := map[string]interface{}{}
+	for k, v := range original {
+		if k != "m.relates_to" && k != "m.new_content" {
+			newContent[k] = v
 		}
 	}
+	newContent["body"] = textMessage
+	newContent["format"] = "org.matrix.custom.html"
+	newContent["formatted_body"] = htmlMessage
 
-	return nil
+	content := map[string]interface{}{}
+	for k, v := range newContent {
+		content[k] = v
+	}
+	content["body"] = "* " + textMessage
+	content["formatted_body"] = "* " + htmlMessage
+	content["m.new_content"] = newContent
+	content["m.relates_to"] = map[string]interface{}{
+		"rel_type": "m.replace",
+		"event_id": eventID,
+	}
+	return mc.sendEvent(ctx, content)
+}
+
+// eventContent fetches the content of an event in the room, decrypting it in encrypted rooms
+func (mc *MatrixClient) eventContent(eventID mautrixID.EventID) (map[string]interface{}, error) {
+	evt, err := mc.client.GetEvent(mc.roomID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	if evt.Type == event.EventEncrypted {
+		if mc.client.Crypto == nil {
+			return nil, fmt.Errorf("event is encrypted and encryption is not enabled")
+		}
+		if err := evt.Content.ParseRaw(evt.Type); err != nil {
+			return nil, err
+		}
+		if evt, err = mc.client.Crypto.Decrypt(evt); err != nil {
+			return nil, fmt.Errorf("failed to decrypt: %v", err)
+		}
+	}
+	if evt.Content.Raw == nil {
+		return nil, fmt.Errorf("event has no content")
+	}
+	return evt.Content.Raw, nil
 }
 
-// formatGameMessageText creates a plain text version of the game message
-func formatGameMessageText(gameName, releaseDate, rating, genres, platforms, summary, downloadLink string) string {
-	return `🎮 **` + gameName + `**
-📅 Release Date: ` + releaseDate + `
-⭐ Rating: ` + rating + `/100
-🎯 Genres: ` + genres + `
-🖥️ Platforms: ` + platforms + `
-📝 Summary: ` + summary
+// renderGameMessage renders the notification of a game with the route's templates and verbosity
+func renderGameMessage(gameInfo *IGDBGameInfo, release *Release, route *RoomRoute) (string, string, error) {
+	return route.messageTemplates().Render(templateGame, &MessageData{
+		Name:      gameInfo.Title,
+		Game:      gameInfo,
+		Release:   release,
+		Verbosity: route.verbosity(),
+	})
 }
 
-// formatGameMessageHTML creates an HTML version of the game message
-func formatGameMessageHTML(gameName, releaseDate, rating, genres, platforms, summary, downloadLink string) string {
-	return `<h3>🎮 <strong>` + gameName + `</strong></h3>
-<p><strong>📅 Release Date:</strong> ` + releaseDate + `</p>
-<p><strong>⭐ Rating:</strong> ` + rating + `/100</p>
-<p><strong>🎯 Genres:</strong> ` + genres + `</p>
-<p><strong>🖥️ Platforms:</strong> ` + platforms + `</p>
-<p><strong>📝 Summary:</strong> ` + summary + `</p>`
+// preparedImage is an image uploaded to the homeserver, ready to be posted
+type preparedImage struct {
+	Caption  string
+	Filename string
+	Image    *uploadedMedia
+	Thumb    *uploadedMedia
+	Blurhash string
 }
 
-// sendMatrixImage sends an m.image event to the Matrix room
-func (mc *MatrixClient) sendMatrixImage(caption, filename string, imgURL, thumbURL string, imgInfo, thumbInfo *MatrixImageInfo, blurhash string, threadRootID mautrixID.EventID, replyID mautrixID.EventID) (mautrixID.EventID, error) {
-	imgInfo.ThumbnailURL = thumbURL
-	imgInfo.ThumbnailInfo = thumbInfo
+// imageContent builds the fields an m.image event and a gallery item share
+func imageContent(caption, filename string, img, thumb *uploadedMedia, blurhash string) map[string]interface{} {
+	imgInfo := *img.Info
+	// Small images have no thumbnail; clients show the original
+	if thumb != nil {
+		imgInfo.ThumbnailInfo = thumb.Info
+		if thumb.File != nil {
+			imgInfo.ThumbnailFile = thumb.File
+		} else {
+			imgInfo.ThumbnailURL = thumb.URL
+		}
+	}
 	if blurhash != "" {
 		if imgInfo.Additional == nil {
 			imgInfo.Additional = map[string]interface{}{}
@@ -202,18 +474,23 @@ func (mc *MatrixClient) sendMatrixImage(caption, filename string, imgURL, thumbU
 	}
 
 	content := map[string]interface{}{
-		"msgtype":  "m.image",
 		"body":     caption,
-		"url":      imgURL,
 		"info":     imgInfo,
 		"filename": filename,
 	}
+	setMediaSource(content, img)
+	for k, v := range imgInfo.Additional {
+		content[k] = v
+	}
+	return content
+}
 
-	// Relationship handling
+// setRelation makes an event a threaded reply when threadRootID is set, or a plain reply when only replyID is
+func setRelation(content map[string]interface{}, threadRootID, replyID mautrixID.EventID) error {
 	if threadRootID != "" {
 		// Threaded reply: replyID is required
 		if replyID == "" {
-			return "", fmt.Errorf("replyID must be set when replying in a thread")
+			return fmt.Errorf("replyID must be set when replying in a thread")
 		}
 		content["m.relates_to"] = map[string]interface{}{
 			"event_id":        threadRootID,
@@ -232,104 +509,146 @@ func (mc *MatrixClient) sendMatrixImage(caption, filename string, imgURL, thumbU
 			},
 		}
 	}
+	return nil
+}
 
-	for k, v := range imgInfo.Additional {
-		content[k] = v
-	}
-	evt, err := mc.client.SendMessageEvent(mautrixID.RoomID(mc.roomID), mautrixEvent.EventMessage, content)
-	return evt.EventID, err
+// sendMatrixImage sends an m.image event to the Matrix room
+func (mc *MatrixClient) sendMatrixImage(ctx context.Context, caption, filename string, img, thumb *uploadedMedia, blurhash string, threadRootID mautrixID.EventID, replyID mautrixID.EventID) (mautrixID.EventID, error) {
+	return mc.sendMatrixImageHTML(ctx, caption, "", filename, img, thumb, blurhash, threadRootID, replyID)
 }
 
 // sendMatrixImageHTML sends an m.image event to the Matrix room with HTML body as well
-func (mc *MatrixClient) sendMatrixImageHTML(caption, htmlCaption, filename string, imgURL, thumbURL string, imgInfo, thumbInfo *MatrixImageInfo, blurhash string, threadRootID mautrixID.EventID, replyID mautrixID.EventID) (mautrixID.EventID, error) {
-	imgInfo.ThumbnailURL = thumbURL
-	imgInfo.ThumbnailInfo = thumbInfo
-	if blurhash != "" {
-		if imgInfo.Additional == nil {
-			imgInfo.Additional = map[string]interface{}{}
-		}
-		imgInfo.Additional["xyz.amorgan.blurhash"] = blurhash
+func (mc *MatrixClient) sendMatrixImageHTML(ctx context.Context, caption, htmlCaption, filename string, img, thumb *uploadedMedia, blurhash string, threadRootID mautrixID.EventID, replyID mautrixID.EventID) (mautrixID.EventID, error) {
+	content := imageContent(caption, filename, img, thumb, blurhash)
+	content["msgtype"] = "m.image"
+	if htmlCaption != "" {
+		content["format"] = "org.matrix.custom.html"
+		content["formatted_body"] = htmlCaption
 	}
+	if err := setRelation(content, threadRootID, replyID); err != nil {
+		return "", err
+	}
+	return mc.sendEvent(ctx, content)
+}
 
+// sendMatrixGallery sends several images as a single gallery event (MSC4274). Clients without
+// gallery support only show the caption, so rooms opt in with MATRIX_GALLERY.
+func (mc *MatrixClient) sendMatrixGallery(ctx context.Context, caption string, images []*preparedImage, threadRootID mautrixID.EventID, replyID mautrixID.EventID) (mautrixID.EventID, error) {
+	items := make([]map[string]interface{}, 0, len(images))
+	for _, img := range images {
+		item := imageContent(img.Caption, img.Filename, img.Image, img.Thumb, img.Blurhash)
+		item["itemtype"] = "m.image"
+		items = append(items, item)
+	}
 	content := map[string]interface{}{
-		"msgtype":        "m.image",
-		"body":           caption,
-		"url":            imgURL,
-		"info":           imgInfo,
-		"filename":       filename,
-		"format":         "org.matrix.custom.html",
-		"formatted_body": htmlCaption,
+		"msgtype":   "dm.filament.gallery",
+		"body":      caption,
+		"itemtypes": items,
+	}
+	if err := setRelation(content, threadRootID, replyID); err != nil {
+		return "", err
 	}
+	return mc.sendEvent(ctx, content)
+}
 
-	// Relationship handling
-	if threadRootID != "" {
-		// Threaded reply: replyID is required
-		if replyID == "" {
-			return "", fmt.Errorf("replyID must be set when replying in a thread")
+// setMediaSource points an image event at its uploaded file, which is encrypted in encrypted rooms
+func setMediaSource(content map[string]interface{}, media *uploadedMedia) {
+	if media.File != nil {
+		content["file"] = media.File
+	} else {
+		content["url"] = media.URL
+	}
+}
+
+// prepareIGDBImage downloads, thumbs, blurhashes and uploads an image without posting it.
+// name is the file name without extension. Images posted before are taken from the media cache,
+// by IGDB image ID before downloading and by checksum after, so they are not uploaded again.
+func (mc *MatrixClient) prepareIGDBImage(ctx context.Context, imgURL, caption, name string, preset ThumbnailPreset) (*preparedImage, error) {
+	variant := mediaVariant(preset, mc.jpegQuality)
+	encrypt := mc.roomEncrypted()
+	imageID := igdbImageID(imgURL)
+
+	media, err := mc.media.ByImageID(imageID, variant, encrypt)
+	if err != nil {
+		log.Printf("Failed to look up media cache: %v", err)
+	}
+	if media == nil {
+		img, imgBytes, format, err := mc.fetcher.Fetch(ctx, imgURL)
+		if err != nil {
+			log.Printf("Failed to download image: %v", err)
+			return nil, err
 		}
-		content["m.relates_to"] = map[string]interface{}{
-			"event_id":        threadRootID,
-			"rel_type":        "m.thread",
-			"is_falling_back": true,
-			"m.in_reply_to": map[string]interface{}{
-				"event_id": replyID,
-			},
+		checksum := mediaChecksum(imgBytes)
+		if media, err = mc.media.ByChecksum(checksum, variant, encrypt); err != nil {
+			log.Printf("Failed to look up media cache: %v", err)
 		}
-
-	} else if replyID != "" {
-		// Normal reply (non-threaded)
-		content["m.relates_to"] = map[string]interface{}{
-			"m.in_reply_to": map[string]interface{}{
-				"event_id": replyID,
-			},
+		if media != nil {
+			if err := mc.media.StoreSource(imageID, checksum); err != nil {
+				log.Printf("Failed to update media cache: %v", err)
+			}
+		} else {
+			if media, err = mc.uploadImage(ctx, img, imgBytes, format, name, preset, encrypt); err != nil {
+				return nil, err
+			}
+			if err := mc.media.Store(imageID, checksum, variant, encrypt, media); err != nil {
+				log.Printf("Failed to update media cache: %v", err)
+			}
 		}
 	}
 
-	for k, v := range imgInfo.Additional {
-		content[k] = v
-	}
-	evt, err := mc.client.SendMessageEvent(mautrixID.RoomID(mc.roomID), mautrixEvent.EventMessage, content)
-	return evt.EventID, err
+	return &preparedImage{
+		Caption:  caption,
+		Filename: imageFilename(name, media.Format),
+		Image:    media.Image,
+		Thumb:    media.Thumb,
+		Blurhash: media.Blurhash,
+	}, nil
 }
 
-// postIGDBImageToMatrix downloads, thumbs, blurhashes, uploads, and posts an image to Matrix
-func (mc *MatrixClient) postIGDBImageToMatrix(imgURL, caption string, htmlCaption string, threadRootID mautrixID.EventID, replyID mautrixID.EventID) (mautrixID.EventID, error) {
-	img, imgBytes, format, err := downloadImage(imgURL)
-	if err != nil {
-		log.Printf("Failed to download image: %v", err)
-		return "", err
-	}
-	var (
-		EventID mautrixID.EventID
-	)
-	thumb := generateThumbnail(img, 225, 300)
-	thumbBytes, _ := encodeImage(thumb, format)
-	blur, _ := calcBlurhash(thumb)
-	imgMimetype := "image/" + format
-	thumbMimetype := imgMimetype
-	imgURLMXC, imgInfo, err := uploadToMatrix(mc.client, caption+".webp", imgBytes, imgMimetype, img.Bounds().Dx(), img.Bounds().Dy())
+// uploadImage uploads an image and its thumbnail. Images that already fit the thumbnail preset
+// get no thumbnail, so clients show the original.
+func (mc *MatrixClient) uploadImage(ctx context.Context, img image.Image, imgBytes []byte, format, name string, preset ThumbnailPreset, encrypt bool) (*CachedMedia, error) {
+	imgMedia, err := uploadToMatrix(ctx, mc.client, imageFilename(name, format), imgBytes, imageMimetype(imgBytes), img.Bounds().Dx(), img.Bounds().Dy(), encrypt)
 	if err != nil {
 		log.Printf("Failed to upload image: %v", err)
-		return "", err
-	}
-	thumbURLMXC, thumbInfo, err := uploadToMatrix(mc.client, caption+"_thumb.webp", thumbBytes, thumbMimetype, thumb.Bounds().Dx(), thumb.Bounds().Dy())
-	if err != nil {
-		log.Printf("Failed to upload thumbnail: %v", err)
-		return "", err
+		return nil, err
 	}
-	if htmlCaption == "" {
-		EventID, err = mc.sendMatrixImage(caption, caption+".webp", imgURLMXC, thumbURLMXC, imgInfo, thumbInfo, blur, threadRootID, replyID)
+
+	var thumbMedia *uploadedMedia
+	thumb := img
+	if !preset.Fits(img.Bounds().Dx(), img.Bounds().Dy()) {
+		thumb = generateThumbnail(img, preset)
+		thumbFormat := thumbnailFormat(format)
+		thumbBytes, err := encodeImage(thumb, thumbFormat, mc.jpegQuality)
 		if err != nil {
-			log.Printf("Failed to send image event: %v", err)
-			return "", err
+			log.Printf("Failed to encode thumbnail: %v", err)
+			return nil, err
 		}
-	} else {
-		EventID, err = mc.sendMatrixImageHTML(caption, htmlCaption, caption+".webp", imgURLMXC, thumbURLMXC, imgInfo, thumbInfo, blur, threadRootID, replyID)
+		thumbMedia, err = uploadToMatrix(ctx, mc.client, imageFilename(name+"_thumb", thumbFormat), thumbBytes, imageMimetype(thumbBytes), thumb.Bounds().Dx(), thumb.Bounds().Dy(), encrypt)
 		if err != nil {
-			log.Printf("Failed to send image event: %v", err)
-			return "", err
+			log.Printf("Failed to upload thumbnail: %v", err)
+			return nil, err
 		}
 	}
+	blur, _ := calcBlurhash(thumb)
+	return &CachedMedia{
+		Format:   format,
+		Image:    imgMedia,
+		Thumb:    thumbMedia,
+		Blurhash: blur,
+	}, nil
+}
 
-	return EventID, err
+// postIGDBImageToMatrix downloads, thumbs, blurhashes, uploads, and posts an image to Matrix
+func (mc *MatrixClient) postIGDBImageToMatrix(ctx context.Context, imgURL, caption, htmlCaption, name string, preset ThumbnailPreset, threadRootID mautrixID.EventID, replyID mautrixID.EventID) (mautrixID.EventID, error) {
+	img, err := mc.prepareIGDBImage(ctx, imgURL, caption, name, preset)
+	if err != nil {
+		return "", err
+	}
+	eventID, err := mc.sendMatrixImageHTML(ctx, img.Caption, htmlCaption, img.Filename, img.Image, img.Thumb, img.Blurhash, threadRootID, replyID)
+	if err != nil {
+		log.Printf("Failed to send image event: %v", err)
+		return "", err
+	}
+	return eventID, nil
 }
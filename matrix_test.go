@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"maunium.net/go/mautrix"
+)
+
+func TestMatrixSendsStopAfterCancel(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"event_id":"$sent","content_uri":"mxc://example.org/media"}`))
+	}))
+	defer srv.Close()
+
+	client, err := mautrix.NewClient(srv.URL, "@bot:example.org", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := &MatrixClient{client: client, roomID: "!room:example.org"}
+
+	eventID, err := mc.SendFormattedMessage(context.Background(), "text", "<b>text</b>")
+	if err != nil || eventID != "$sent" {
+		t.Fatalf("SendFormattedMessage = %q, %v", eventID, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	before := atomic.LoadInt32(&requests)
+	if _, err := mc.SendFormattedMessage(ctx, "text", "<b>text</b>"); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendFormattedMessage after cancel: %v", err)
+	}
+	if _, err := mc.SendReleaseUpdate(ctx, "$root", &IGDBGameInfo{Title: "Portal 2"}, &Release{Title: "Portal.2-RUNE"}, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendReleaseUpdate after cancel: %v", err)
+	}
+	if _, err := uploadToMatrix(ctx, client, "cover.png", []byte("png"), "image/png", 1, 1, false); !errors.Is(err, context.Canceled) {
+		t.Errorf("uploadToMatrix after cancel: %v", err)
+	}
+	if n := atomic.LoadInt32(&requests) - before; n != 0 {
+		t.Errorf("%d requests sent after cancel", n)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// MediaCache remembers images uploaded to the homeserver, keyed by IGDB image ID and by the
+// SHA-256 of their bytes, so that repeat posts reuse them without downloading or uploading again
+type MediaCache struct {
+	db *sql.DB
+}
+
+// NewMediaCache creates a media cache
+func NewMediaCache(db *sql.DB) *MediaCache {
+	return &MediaCache{db: db}
+}
+
+// CachedMedia is an uploaded image with its thumbnail, if it needed one
+type CachedMedia struct {
+	Format   string         `json:"format"`
+	Image    *uploadedMedia `json:"image"`
+	Thumb    *uploadedMedia `json:"thumb,omitempty"`
+	Blurhash string         `json:"blurhash,omitempty"`
+}
+
+// mediaVariant names the thumbnail settings an upload was made with, since changing them
+// must not reuse thumbnails of the old size
+func mediaVariant(preset ThumbnailPreset, jpegQuality int) string {
+	return fmt.Sprintf("%sq%d", preset, jpegQuality)
+}
+
+// mediaChecksum returns the hex SHA-256 of image bytes
+func mediaChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// igdbImageID returns the image ID of an images.igdb.com URL, or "" for other URLs
+func igdbImageID(imgURL string) string {
+	u, err := url.Parse(imgURL)
+	if err != nil || u.Host != "images.igdb.com" {
+		return ""
+	}
+	base := path.Base(u.Path)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// ByImageID returns the cached upload of an IGDB image, or nil
+func (c *MediaCache) ByImageID(imageID, variant string, encrypted bool) (*CachedMedia, error) {
+	if c == nil || imageID == "" {
+		return nil, nil
+	}
+	return c.lookup(`SELECT m.media FROM media_sources s JOIN media_cache m ON m.sha256 = s.sha256
+		WHERE s.image_id = ? AND m.variant = ? AND m.encrypted = ?`, imageID, variant, encrypted)
+}
+
+// ByChecksum returns the cached upload of image bytes with the given SHA-256, or nil
+func (c *MediaCache) ByChecksum(checksum, variant string, encrypted bool) (*CachedMedia, error) {
+	if c == nil {
+		return nil, nil
+	}
+	return c.lookup(`SELECT media FROM media_cache WHERE sha256 = ? AND variant = ? AND encrypted = ?`, checksum, variant, encrypted)
+}
+
+func (c *MediaCache) lookup(query string, args ...interface{}) (*CachedMedia, error) {
+	var data string
+	err := c.db.QueryRow(query, args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var media CachedMedia
+	if err := json.Unmarshal([]byte(data), &media); err != nil {
+		return nil, err
+	}
+	if media.Image == nil {
+		return nil, nil
+	}
+	return &media, nil
+}
+
+// Store caches an upload under its checksum, and under the IGDB image ID when there is one
+func (c *MediaCache) Store(imageID, checksum, variant string, encrypted bool, media *CachedMedia) error {
+	if c == nil {
+		return nil
+	}
+	data, err := json.Marshal(media)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO media_cache (sha256, variant, encrypted, media, created_at) VALUES (?, ?, ?, ?, ?)`,
+		checksum, variant, encrypted, string(data), time.Now().Unix()); err != nil {
+		return err
+	}
+	if err := storeMediaSource(tx, imageID, checksum); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StoreSource records the checksum of an IGDB image, for images whose bytes were already cached
+func (c *MediaCache) StoreSource(imageID, checksum string) error {
+	if c == nil {
+		return nil
+	}
+	return storeMediaSource(c.db, imageID, checksum)
+}
+
+func storeMediaSource(db interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, imageID, checksum string) error {
+	if imageID == "" {
+		return nil
+	}
+	_, err := db.Exec(`INSERT OR REPLACE INTO media_sources (image_id, sha256) VALUES (?, ?)`, imageID, checksum)
+	return err
+}
+
+// InvalidateAll forgets every upload, so images are uploaded again on their next post
+func (c *MediaCache) InvalidateAll() (int64, error) {
+	var total int64
+	for _, table := range []string{"media_sources", "media_cache"} {
+		res, err := c.db.Exec(`DELETE FROM ` + table)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	return total, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIGDBImageID(t *testing.T) {
+	cases := map[string]string{
+		"https://images.igdb.com/igdb/image/upload/t_cover_big/co1wyy.jpg": "co1wyy",
+		"https://images.igdb.com/igdb/image/upload/t_1080p/sc6l2a.webp":    "sc6l2a",
+		"https://example.com/igdb/image/upload/t_cover_big/co1wyy.jpg":     "",
+		"http://[::1]:namedport/co1wyy.jpg":                                "",
+	}
+	for imgURL, want := range cases {
+		if got := igdbImageID(imgURL); got != want {
+			t.Errorf("igdbImageID(%s) = %q, want %q", imgURL, got, want)
+		}
+	}
+}
+
+func TestMediaVariant(t *testing.T) {
+	if got := mediaVariant(ThumbnailPreset{MaxWidth: 225, MaxHeight: 300}, 85); got != "225x300q85" {
+		t.Errorf("mediaVariant = %q", got)
+	}
+}
+
+func TestMediaCache(t *testing.T) {
+	cache := NewMediaCache(newTestDB(t))
+	checksum := mediaChecksum([]byte("cover bytes"))
+	variant := mediaVariant(ThumbnailPreset{MaxWidth: 225, MaxHeight: 300}, 85)
+	media := &CachedMedia{Format: "jpeg", Image: &uploadedMedia{URL: "mxc://example.org/cover"}, Blurhash: "LEHV6n"}
+
+	if got, err := cache.ByChecksum(checksum, variant, false); err != nil || got != nil {
+		t.Fatalf("empty cache: got %+v, %v", got, err)
+	}
+	if err := cache.Store("co1wyy", checksum, variant, false, media); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := cache.ByImageID("co1wyy", variant, false); err != nil || got == nil || got.Image.URL != "mxc://example.org/cover" || got.Blurhash != "LEHV6n" {
+		t.Errorf("ByImageID = %+v, %v", got, err)
+	}
+	if got, err := cache.ByChecksum(checksum, variant, false); err != nil || got == nil || got.Format != "jpeg" {
+		t.Errorf("ByChecksum = %+v, %v", got, err)
+	}
+
+	// Uploads for encrypted rooms and other thumbnail sizes are separate
+	otherVariant := mediaVariant(ThumbnailPreset{MaxWidth: 640, MaxHeight: 360}, 85)
+	for _, lookup := range []struct {
+		variant   string
+		encrypted bool
+	}{{variant, true}, {otherVariant, false}} {
+		if got, err := cache.ByImageID("co1wyy", lookup.variant, lookup.encrypted); err != nil || got != nil {
+			t.Errorf("ByImageID(%s, encrypted %v) = %+v, %v; want a miss", lookup.variant, lookup.encrypted, got, err)
+		}
+	}
+
+	// The same bytes under another IGDB image ID are found once the source is recorded
+	if got, _ := cache.ByImageID("co2abc", variant, false); got != nil {
+		t.Error("unknown image ID found")
+	}
+	if err := cache.StoreSource("co2abc", checksum); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cache.ByImageID("co2abc", variant, false); err != nil || got == nil {
+		t.Errorf("ByImageID after StoreSource = %+v, %v", got, err)
+	}
+
+	if n, err := cache.InvalidateAll(); err != nil || n != 3 {
+		t.Errorf("InvalidateAll = %d, %v; want 3 entries removed", n, err)
+	}
+	if got, _ := cache.ByChecksum(checksum, variant, false); got != nil {
+		t.Error("upload still cached after InvalidateAll")
+	}
+}
+
+func TestNilMediaCache(t *testing.T) {
+	var cache *MediaCache
+	if got, err := cache.ByImageID("co1wyy", "v", false); got != nil || err != nil {
+		t.Errorf("ByImageID = %+v, %v", got, err)
+	}
+	if err := cache.Store("co1wyy", "sum", "v", false, &CachedMedia{}); err != nil {
+		t.Errorf("Store: %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// maxRetryBackoff caps the delay between delivery attempts
+const maxRetryBackoff = 6 * time.Hour
+
+// outboxPayload is what we need to redeliver a release without seeing it in the feed again
+type outboxPayload struct {
+	Release *Release `json:"release"`
+	// Deliveries maps each room that already received the release to its event IDs, so retries skip it
+	Deliveries map[string][]string `json:"deliveries,omitempty"`
+	// Roots maps each room to the notification that newer releases of the game attach to
+	Roots map[string]string `json:"roots,omitempty"`
+}
+
+// queueRelease records a new release as pending before any delivery is attempted,
+// so that a crash or failed send leaves it in the outbox rather than losing it
+func (rp *RSSProcessor) queueRelease(db *sql.DB, feed *FeedConfig, gameName string, release *Release) (*ReleaseRecord, error) {
+	payload, err := json.Marshal(outboxPayload{Release: release})
+	if err != nil {
+		return nil, err
+	}
+	rec := &ReleaseRecord{
+		GUID:          release.GUID,
+		Feed:          feed.Name,
+		RawTitle:      release.Title,
+		ExtractedName: gameName,
+		Status:        ReleaseStatusPending,
+		RoomID:        feed.RoomID,
+		Payload:       string(payload),
+		NextAttemptAt: time.Now(),
+	}
+	if err := saveRelease(db, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// deliverRelease looks up the game and sends the notification to every room the release
+// is routed to, then records the outcome. When ctx is cancelled no further rooms are
+// started, and the release stays pending for the rooms that were not reached.
+func (rp *RSSProcessor) deliverRelease(ctx context.Context, db *sql.DB, rec *ReleaseRecord, payload *outboxPayload) {
+	release := payload.Release
+	gameName := rec.ExtractedName
+
+	// Search IGDB for game information with images
+	igdbInfo, err := rp.igdbClient.SearchGameWithImages(ctx, gameName)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Don't send a notification without game details just because we are shutting down
+			rp.recordDelivery(db, rec, ctx.Err())
+			return
+		}
+		log.Printf("Failed to get IGDB info for %s: %v", gameName, err)
+		igdbInfo = nil
+	} else {
+		rec.IGDBID = igdbInfo.ID
+		rec.MatchScore = igdbInfo.MatchScore
+
+		// The release name may differ from a muted IGDB title
+		if muted, err := isGameMuted(db, igdbInfo.Title); err != nil {
+			log.Printf("Failed to check muted games: %v", err)
+		} else if muted {
+			rp.skipRelease(db, rec, "muted")
+			return
+		}
+	}
+
+	routes := rp.routesFor(rec.Feed, rec.RoomID, release, igdbInfo)
+	if len(routes) == 0 {
+		rp.skipRelease(db, rec, "no matching route")
+		return
+	}
+
+	// A game posted within DEDUPE_WINDOW gets its earlier notification updated instead
+	earlier := rp.findEarlierNotification(db, rec, igdbInfo)
+
+	var sendErr error
+	for _, route := range routes {
+		if _, done := payload.Deliveries[route.RoomID]; done {
+			continue
+		}
+		if ctx.Err() != nil {
+			if sendErr == nil {
+				sendErr = ctx.Err()
+			}
+			break
+		}
+		var eventIDs []string
+		rootID := earlier.rootIn(route.RoomID)
+		if rootID != "" {
+			log.Printf("[%s] %q is a newer release of %s, updating %s", rec.Feed, rec.RawTitle, igdbInfo.Title, rootID)
+			eventIDs, err = rp.sendUpdate(ctx, route, rootID, release, igdbInfo)
+		} else {
+			eventIDs, err = rp.sendToRoute(ctx, route, gameName, release, igdbInfo)
+			if len(eventIDs) > 0 {
+				rootID = eventIDs[0]
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to send Matrix message to %s: %v", route.RoomID, err)
+			if sendErr == nil {
+				sendErr = err
+			}
+			continue
+		}
+		log.Printf("Sent Matrix message for %s to %s (%s)", gameName, route.RoomID, route.Name)
+		payload.addDelivery(route.RoomID, eventIDs)
+		payload.setRoot(route.RoomID, rootID)
+		rec.EventIDs = append(rec.EventIDs, eventIDs...)
+	}
+
+	if data, err := json.Marshal(payload); err == nil {
+		rec.Payload = string(data)
+	}
+	rp.recordDelivery(db, rec, sendErr)
+}
+
+// sendToRoute sends a release to a route's room, with game details when IGDB info is available
+func (rp *RSSProcessor) sendToRoute(ctx context.Context, route *RoomRoute, gameName string, release *Release, igdbInfo *IGDBGameInfo) ([]string, error) {
+	matrixClient := rp.matrixClient.ForRoom(route.RoomID)
+	if igdbInfo == nil {
+		// Send basic notification even without IGDB info
+		eventID, err := matrixClient.SendReleaseNotification(ctx, gameName, release, route)
+		if err != nil {
+			return nil, err
+		}
+		return []string{eventID.String()}, nil
+	}
+	// Send detailed notification with game info and images
+	ids, err := matrixClient.SendGameNotificationWithImages(ctx, igdbInfo, release, route)
+	var eventIDs []string
+	for _, id := range ids {
+		eventIDs = append(eventIDs, id.String())
+	}
+	return eventIDs, err
+}
+
+// addDelivery records that a room received the release
+func (p *outboxPayload) addDelivery(roomID string, eventIDs []string) {
+	if p.Deliveries == nil {
+		p.Deliveries = map[string][]string{}
+	}
+	p.Deliveries[roomID] = append(p.Deliveries[roomID], eventIDs...)
+}
+
+// setRoot records the notification in a room that newer releases of the game attach to
+func (p *outboxPayload) setRoot(roomID, eventID string) {
+	if eventID == "" {
+		return
+	}
+	if p.Roots == nil {
+		p.Roots = map[string]string{}
+	}
+	p.Roots[roomID] = eventID
+}
+
+// skipRelease records a release that will not be delivered
+func (rp *RSSProcessor) skipRelease(db *sql.DB, rec *ReleaseRecord, reason string) {
+	log.Printf("[%s] Skipping %q: %s", rec.Feed, rec.RawTitle, reason)
+	rec.Status = ReleaseStatusSkipped
+	rec.Error = reason
+	rec.NextAttemptAt = time.Time{}
+	if err := saveRelease(db, rec); err != nil {
+		log.Printf("Failed to record release %s: %v", rec.GUID, err)
+	}
+}
+
+// recordDelivery stores the outcome of a delivery attempt. Failed attempts are
+// rescheduled with exponential backoff until the attempt limit dead-letters them.
+// Attempts interrupted by shutdown don't count and are retried on the next poll.
+func (rp *RSSProcessor) recordDelivery(db *sql.DB, rec *ReleaseRecord, sendErr error) {
+	if errors.Is(sendErr, context.Canceled) {
+		rec.Status = ReleaseStatusPending
+		rec.Error = sendErr.Error()
+		rec.NextAttemptAt = time.Now()
+		log.Printf("[%s] Delivery of %q interrupted, it will be retried", rec.Feed, rec.RawTitle)
+		if err := saveRelease(db, rec); err != nil {
+			log.Printf("Failed to record release %s: %v", rec.GUID, err)
+		}
+		return
+	}
+
+	cfg := rp.currentConfig()
+	rec.Attempts++
+	switch {
+	case sendErr == nil:
+		rec.Status = ReleaseStatusSent
+		rec.Error = ""
+		rec.NextAttemptAt = time.Time{}
+	case rec.Attempts >= cfg.OutboxMaxAttempts:
+		rec.Status = ReleaseStatusDead
+		rec.Error = sendErr.Error()
+		rec.NextAttemptAt = time.Time{}
+		log.Printf("[%s] Giving up on %q after %d attempts: %v", rec.Feed, rec.RawTitle, rec.Attempts, sendErr)
+	default:
+		backoff := retryBackoff(cfg.OutboxRetryBackoff, rec.Attempts)
+		rec.Status = ReleaseStatusPending
+		rec.Error = sendErr.Error()
+		rec.NextAttemptAt = time.Now().Add(backoff)
+		log.Printf("[%s] Delivery of %q failed (attempt %d/%d), retrying in %s", rec.Feed, rec.RawTitle, rec.Attempts, cfg.OutboxMaxAttempts, backoff)
+	}
+
+	if err := saveRelease(db, rec); err != nil {
+		log.Printf("Failed to record release %s: %v", rec.GUID, err)
+	}
+}
+
+// retryPendingReleases redelivers a feed's pending releases whose backoff has elapsed
+func (rp *RSSProcessor) retryPendingReleases(ctx context.Context, db *sql.DB, feed *FeedConfig) error {
+	recs, err := dueReleases(db, feed.Name, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to load pending releases: %v", err)
+	}
+	if len(recs) > 0 {
+		log.Printf("[%s] Retrying %d pending releases", feed.Name, len(recs))
+	}
+
+	for _, rec := range recs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var payload outboxPayload
+		reason := ""
+		if err := json.Unmarshal([]byte(rec.Payload), &payload); err != nil {
+			reason = fmt.Sprintf("unreadable outbox payload: %v", err)
+		} else if payload.Release == nil {
+			reason = "outbox payload has no release"
+		}
+		if reason != "" {
+			rec.Status = ReleaseStatusDead
+			rec.Error = reason
+			if err := saveRelease(db, rec); err != nil {
+				log.Printf("Failed to record release %s: %v", rec.GUID, err)
+			}
+			continue
+		}
+		// Follow the feed's current room so a corrected room setting applies to retries
+		if feed.RoomID != "" {
+			rec.RoomID = feed.RoomID
+		}
+		rp.deliverRelease(ctx, db, rec, &payload)
+		if err := sleepContext(ctx, 2*time.Second); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryBackoff returns base * 2^(attempt-1), capped at maxRetryBackoff
+func retryBackoff(base time.Duration, attempt int) time.Duration {
+	backoff := base
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	cases := []struct {
+		base    time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{time.Minute, 0, time.Minute},
+		{time.Minute, 1, time.Minute},
+		{time.Minute, 2, 2 * time.Minute},
+		{time.Minute, 5, 16 * time.Minute},
+		{time.Minute, 9, 256 * time.Minute},
+		{time.Minute, 10, maxRetryBackoff},
+		{time.Minute, 1000, maxRetryBackoff},
+		{10 * time.Hour, 1, maxRetryBackoff},
+	}
+	for _, tc := range cases {
+		if got := retryBackoff(tc.base, tc.attempt); got != tc.want {
+			t.Errorf("retryBackoff(%s, %d) = %s, want %s", tc.base, tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestRecordDelivery(t *testing.T) {
+	db := newTestDB(t)
+	rp := &RSSProcessor{config: &Config{OutboxMaxAttempts: 3, OutboxRetryBackoff: time.Minute}}
+	feed := &FeedConfig{Name: "zamunda", RoomID: "!room:example.org"}
+	rec, err := rp.queueRelease(db, feed, "Starfield", &Release{GUID: "g", Title: "Starfield-RUNE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if due, err := dueReleases(db, "zamunda", time.Now()); err != nil || len(due) != 1 {
+		t.Fatalf("queued release: %d due, %v", len(due), err)
+	}
+
+	// Shutting down doesn't use up an attempt and retries on the next poll
+	rp.recordDelivery(db, rec, context.Canceled)
+	if rec.Status != ReleaseStatusPending || rec.Attempts != 0 {
+		t.Errorf("after cancel: status %s, %d attempts", rec.Status, rec.Attempts)
+	}
+
+	sendErr := errors.New("homeserver unavailable")
+	started := time.Now()
+	rp.recordDelivery(db, rec, sendErr)
+	if rec.Status != ReleaseStatusPending || rec.Attempts != 1 || rec.Error != sendErr.Error() {
+		t.Errorf("after a failure: status %s, %d attempts, error %q", rec.Status, rec.Attempts, rec.Error)
+	}
+	if wait := rec.NextAttemptAt.Sub(started); wait < time.Minute || wait > time.Minute+time.Second {
+		t.Errorf("first retry scheduled in %s, want 1m", wait)
+	}
+	if due, _ := dueReleases(db, "zamunda", time.Now()); len(due) != 0 {
+		t.Error("release is due before its backoff elapsed")
+	}
+	if due, _ := dueReleases(db, "zamunda", time.Now().Add(2*time.Minute)); len(due) != 1 {
+		t.Error("release is not due after its backoff elapsed")
+	}
+
+	rp.recordDelivery(db, rec, sendErr)
+	if wait := rec.NextAttemptAt.Sub(started); wait < 2*time.Minute {
+		t.Errorf("second retry scheduled in %s, want 2m", wait)
+	}
+	rp.recordDelivery(db, rec, sendErr)
+	if rec.Status != ReleaseStatusDead || !rec.NextAttemptAt.IsZero() {
+		t.Errorf("after %d attempts: status %s, next attempt %v; want it dead-lettered", rec.Attempts, rec.Status, rec.NextAttemptAt)
+	}
+	if due, _ := dueReleases(db, "zamunda", time.Now().Add(24*time.Hour)); len(due) != 0 {
+		t.Error("dead-lettered release is still due")
+	}
+}
+
+func TestRecordDeliverySent(t *testing.T) {
+	db := newTestDB(t)
+	rp := &RSSProcessor{config: &Config{OutboxMaxAttempts: 3, OutboxRetryBackoff: time.Minute}}
+	rec, err := rp.queueRelease(db, &FeedConfig{Name: "zamunda"}, "Starfield", &Release{GUID: "g", Title: "Starfield-RUNE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp.recordDelivery(db, rec, errors.New("timeout"))
+	rp.recordDelivery(db, rec, nil)
+	if rec.Status != ReleaseStatusSent || rec.Error != "" || rec.Attempts != 2 {
+		t.Errorf("status %s, error %q, %d attempts", rec.Status, rec.Error, rec.Attempts)
+	}
+	if processed, err := isPostProcessed(db, "g"); err != nil || !processed {
+		t.Errorf("isPostProcessed = %v, %v", processed, err)
+	}
+}
+
+func TestDeliverReleaseWithoutRoutes(t *testing.T) {
+	db := newTestDB(t)
+	ic, _ := newTestIGDBClient(t)
+	rp := &RSSProcessor{config: &Config{OutboxMaxAttempts: 3, OutboxRetryBackoff: time.Minute}, igdbClient: ic}
+	// No feed room and no routing rules: the release has nowhere to go
+	release := &Release{GUID: "g", Title: "Portal 2-RUNE"}
+	rec, err := rp.queueRelease(db, &FeedConfig{Name: "zamunda"}, "Portal 2", release)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rp.deliverRelease(context.Background(), db, rec, &outboxPayload{Release: release})
+	if rec.Status != ReleaseStatusSkipped || rec.Error != "no matching route" {
+		t.Errorf("status %s, error %q; want it skipped for having no route", rec.Status, rec.Error)
+	}
+	if due, _ := dueReleases(db, "zamunda", time.Now().Add(24*time.Hour)); len(due) != 0 {
+		t.Error("release without routes is still due")
+	}
+}
+
+func TestRetryPendingReleasesBadPayload(t *testing.T) {
+	db := newTestDB(t)
+	rp := &RSSProcessor{config: &Config{OutboxMaxAttempts: 3, OutboxRetryBackoff: time.Minute}}
+	feed := &FeedConfig{Name: "zamunda", RoomID: "!room:example.org"}
+	for guid, payload := range map[string]string{"garbled": "{", "empty": "{}"} {
+		rec := &ReleaseRecord{GUID: guid, Feed: feed.Name, Status: ReleaseStatusPending, Payload: payload, NextAttemptAt: time.Now()}
+		if err := saveRelease(db, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rp.retryPendingReleases(context.Background(), db, feed); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := recentReleases(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		want := "outbox payload has no release"
+		if rec.GUID == "garbled" {
+			want = "unreadable outbox payload: unexpected end of JSON input"
+		}
+		if rec.Status != ReleaseStatusDead || rec.Error != want {
+			t.Errorf("%s: status %s, error %q; want it dead-lettered with %q", rec.GUID, rec.Status, rec.Error, want)
+		}
+	}
+	if len(recs) != 2 {
+		t.Errorf("got %d releases, want 2", len(recs))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitedTransport spaces out requests to stay under an API's rate limit and
+// backs off when the server answers 429 Too Many Requests. A single transport
+// should be shared by every client talking to the same API.
+type RateLimitedTransport struct {
+	Transport  http.RoundTripper
+	interval   time.Duration
+	maxRetries int
+	sem        chan struct{}
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewRateLimitedTransport allows perSecond requests per second with at most maxInFlight open at once,
+// retrying a request up to maxRetries times when it is rate-limited
+func NewRateLimitedTransport(transport http.RoundTripper, perSecond, maxInFlight, maxRetries int) *RateLimitedTransport {
+	return &RateLimitedTransport{
+		Transport:  transport,
+		interval:   time.Second / time.Duration(perSecond),
+		maxRetries: maxRetries,
+		sem:        make(chan struct{}, maxInFlight),
+	}
+}
+
+// RoundTrip waits for a free slot, sends the request and retries on 429
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+
+		select {
+		case t.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		resp, err := t.Transport.RoundTrip(req)
+		if err != nil {
+			<-t.sem
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || attempt >= t.maxRetries || (req.Body != nil && req.GetBody == nil) {
+			// The request stays in flight until the caller is done reading the body
+			resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: func() { <-t.sem }}
+			return resp, nil
+		}
+
+		backoff := retryAfter(resp, time.Duration(1<<attempt)*time.Second)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		<-t.sem
+		log.Printf("Rate limited by %s, retrying in %s", req.URL.Host, backoff)
+		t.pause(backoff)
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// releaseOnClose calls release once, when the body is first closed
+type releaseOnClose struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// wait blocks until the request may be sent according to the configured rate
+func (t *RateLimitedTransport) wait(req *http.Request) error {
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(t.interval)
+	t.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-req.Context().Done():
+		return req.Context().Err()
+	}
+}
+
+// pause holds back every request sharing this transport for d
+func (t *RateLimitedTransport) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); t.next.Before(until) {
+		t.next = until
+	}
+}
+
+// retryAfter reads the Retry-After header (in seconds), falling back to the given backoff
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return fallback
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedTransportSpacesRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	client := &http.Client{Transport: NewRateLimitedTransport(http.DefaultTransport, 20, 4, 0)}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	// Four requests at 20/s need at least three 50ms gaps
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("4 requests took %s, want at least 150ms", elapsed)
+	}
+}
+
+func TestRateLimitedTransportRetriesOn429(t *testing.T) {
+	var calls int32
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: NewRateLimitedTransport(http.DefaultTransport, 100, 4, 2)}
+
+	start := time.Now()
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("fields name;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want to honour Retry-After: 1", elapsed)
+	}
+	// The request body is replayed through GetBody on the retry
+	if len(bodies) != 2 || bodies[0] != "fields name;" || bodies[1] != "fields name;" {
+		t.Errorf("server saw bodies %q, want the same body twice", bodies)
+	}
+}
+
+func TestRateLimitedTransportGivesUpAfterMaxRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: NewRateLimitedTransport(http.DefaultTransport, 100, 4, 0)}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || calls != 1 {
+		t.Errorf("status = %d after %d calls, want the 429 passed through after 1 call", resp.StatusCode, calls)
+	}
+}
+
+func TestRateLimitedTransportContextCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	// One request per second: the second request has to wait and sees the cancelled context
+	client := &http.Client{Transport: NewRateLimitedTransport(http.DefaultTransport, 1, 1, 0)}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := client.Do(req); err == nil {
+		t.Error("request succeeded, want the context error while waiting for the rate limit")
+	}
+}
+
+func TestRateLimitedTransportHoldsSlotUntilBodyClosed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: NewRateLimitedTransport(http.DefaultTransport, 100, 1, 0)}
+
+	first, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The only slot is taken until the first body is closed
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("second request got a slot while the first body was open")
+	}
+
+	first.Body.Close()
+	first.Body.Close()
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request after closing the body: %v", err)
+	}
+	resp.Body.Close()
+}
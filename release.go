@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Release holds the torrent metadata of a feed item that we show in notifications
+type Release struct {
+	GUID       string
+	Feed       string
+	Title      string
+	Indexer    string
+	Size       int64
+	Seeders    int
+	Leechers   int
+	Grabs      int
+	PubDate    time.Time
+	DetailsURL string
+	TorrentURL string
+	MagnetURL  string
+	InfoHash   string
+
+	// Details parsed from the title
+	Version string
+	Build   string
+	Group   string
+	Repack  bool
+	DLCs    []string
+}
+
+// NewReleaseFromItem builds a Release from a Torznab item of the given feed
+func NewReleaseFromItem(feed *FeedConfig, item *TorznabItem) *Release {
+	release := &Release{
+		GUID:       item.GUID,
+		Feed:       feed.Name,
+		Title:      item.Title,
+		Indexer:    item.Indexer,
+		Size:       item.Size,
+		Seeders:    item.Seeders,
+		Leechers:   item.Leechers(),
+		Grabs:      item.Grabs,
+		PubDate:    item.PubDate,
+		DetailsURL: item.Comments,
+		TorrentURL: item.EnclosureURL,
+		MagnetURL:  item.MagnetURL,
+		InfoHash:   item.InfoHash,
+	}
+	if release.Indexer == "" {
+		release.Indexer = feed.Name
+	}
+	if release.TorrentURL == "" && !strings.HasPrefix(item.Link, "magnet:") {
+		release.TorrentURL = item.Link
+	}
+	if release.DetailsURL == "" && strings.HasPrefix(item.GUID, "http") && item.GUID != release.TorrentURL {
+		release.DetailsURL = item.GUID
+	}
+	if release.MagnetURL == "" && release.InfoHash != "" {
+		release.MagnetURL = "magnet:?xt=urn:btih:" + release.InfoHash + "&dn=" + url.QueryEscape(release.Title)
+	}
+	return release
+}
+
+// SetParsedTitle copies the release details parsed from the title
+func (r *Release) SetParsedTitle(pt *ParsedTitle) {
+	r.Version = pt.Version
+	r.Build = pt.Build
+	r.Group = pt.Group
+	r.Repack = pt.Repack
+	r.DLCs = pt.DLCs
+}
+
+// formatSize formats a byte count as a human-readable size
+func formatSize(bytes int64) string {
+	if bytes <= 0 {
+		return "Unknown"
+	}
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
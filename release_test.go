@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewReleaseFromItem(t *testing.T) {
+	items, err := parseTorznabFeed([]byte(testTorznabFeed))
+	if err != nil {
+		t.Fatalf("parseTorznabFeed: %v", err)
+	}
+	feed := &FeedConfig{Name: "zamunda"}
+
+	tests := []struct {
+		name string
+		item *TorznabItem
+		want *Release
+	}{
+		{
+			name: "torznab attrs",
+			item: items[0],
+			want: &Release{
+				GUID:       "https://zamunda.net/details.php?id=1",
+				Feed:       "zamunda",
+				Title:      "Starfield-RUNE",
+				Indexer:    "Zamunda",
+				Size:       125000000000,
+				Seeders:    42,
+				Leechers:   8,
+				Grabs:      1000,
+				PubDate:    time.Date(2023, 9, 6, 7, 15, 0, 0, time.UTC),
+				DetailsURL: "https://zamunda.net/details.php?id=1",
+				TorrentURL: "https://jackett.local/dl/zamunda/?path=1",
+				MagnetURL:  "magnet:?xt=urn:btih:abcdef0123&dn=Starfield-RUNE",
+				InfoHash:   "abcdef0123",
+			},
+		},
+		{
+			name: "magnet link without torrent",
+			item: &TorznabItem{
+				Title:     "Hades II-TENOKE",
+				GUID:      "hades-2",
+				Link:      "magnet:?xt=urn:btih:0123456789",
+				MagnetURL: "magnet:?xt=urn:btih:0123456789",
+				Seeders:   3,
+				Peers:     1,
+			},
+			want: &Release{
+				GUID:      "hades-2",
+				Feed:      "zamunda",
+				Title:     "Hades II-TENOKE",
+				Indexer:   "zamunda",
+				Seeders:   3,
+				MagnetURL: "magnet:?xt=urn:btih:0123456789",
+			},
+		},
+		{
+			name: "link and guid fallbacks",
+			item: &TorznabItem{
+				Title: "Hollow Knight",
+				GUID:  "https://tracker.example/t/7",
+				Link:  "https://jackett.local/dl/7",
+			},
+			want: &Release{
+				GUID:       "https://tracker.example/t/7",
+				Feed:       "zamunda",
+				Title:      "Hollow Knight",
+				Indexer:    "zamunda",
+				DetailsURL: "https://tracker.example/t/7",
+				TorrentURL: "https://jackett.local/dl/7",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := NewReleaseFromItem(feed, tc.item)
+			if !got.PubDate.Equal(tc.want.PubDate) {
+				t.Errorf("PubDate = %v, want %v", got.PubDate, tc.want.PubDate)
+			}
+			got.PubDate, tc.want.PubDate = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("NewReleaseFromItem = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		bytes int64
+		want  string
+	}{
+		{0, "Unknown"},
+		{-1, "Unknown"},
+		{512, "512 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{125000000000, "116.4 GiB"},
+		{3 << 40, "3.0 TiB"},
+	}
+	for _, tc := range tests {
+		if got := formatSize(tc.bytes); got != tc.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tc.bytes, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Notification verbosity levels
+const (
+	VerbosityFull    = "full"    // every IGDB field and the full summary
+	VerbosityCompact = "compact" // rating, genres, platforms and a short summary
+	VerbosityMinimal = "minimal" // game name, release date and the release details
+)
+
+// maxRouteScreenshots caps the screenshots a route may ask for
+const maxRouteScreenshots = 10
+
+// RoomRoute sends releases matching all of its conditions to a room, formatted for that room.
+// Empty conditions match everything; list conditions match if any entry matches.
+type RoomRoute struct {
+	Name         string
+	RoomID       string
+	Feeds        []string
+	Genres       []string
+	Platforms    []string
+	Groups       []string
+	MinRating    float64
+	TitlePattern *regexp.Regexp
+	Verbosity    string
+	Screenshots  int
+	Gallery      bool
+	TemplateDir  string
+	Templates    *MessageTemplates
+}
+
+// messageTemplates returns the templates of the route's room, or the defaults when route is nil
+func (r *RoomRoute) messageTemplates() *MessageTemplates {
+	if r == nil || r.Templates == nil {
+		return defaultTemplates
+	}
+	return r.Templates
+}
+
+// verbosity returns the verbosity of the route's room, or full when route is nil
+func (r *RoomRoute) verbosity() string {
+	if r == nil || r.Verbosity == "" {
+		return VerbosityFull
+	}
+	return r.Verbosity
+}
+
+// needsIGDB reports whether the route can only match releases with IGDB info
+func (r *RoomRoute) needsIGDB() bool {
+	return len(r.Genres) > 0 || len(r.Platforms) > 0 || r.MinRating > 0
+}
+
+// Matches reports whether a release of the given feed, with its IGDB info if any, belongs in the route's room
+func (r *RoomRoute) Matches(feed string, release *Release, info *IGDBGameInfo) bool {
+	if len(r.Feeds) > 0 && !containsFold(r.Feeds, feed) {
+		return false
+	}
+	if len(r.Groups) > 0 && !containsFold(r.Groups, release.Group) {
+		return false
+	}
+	if r.TitlePattern != nil && !r.TitlePattern.MatchString(release.Title) {
+		return false
+	}
+	if r.needsIGDB() && info == nil {
+		return false
+	}
+	if len(r.Genres) > 0 && !anySubstringFold(info.Genres, r.Genres) {
+		return false
+	}
+	if len(r.Platforms) > 0 && !anySubstringFold(info.Platforms, r.Platforms) {
+		return false
+	}
+	if r.MinRating > 0 {
+		if info.OverallRating() < r.MinRating {
+			return false
+		}
+	}
+	return true
+}
+
+// routesFor returns the rooms a release goes to: the feed's own room first, then every matching
+// route. A room is only used once, with the settings of the first route that selected it.
+func (rp *RSSProcessor) routesFor(feed, feedRoomID string, release *Release, info *IGDBGameInfo) []*RoomRoute {
+	cfg := rp.currentConfig()
+	routes := []*RoomRoute{}
+	seen := map[string]bool{}
+	if feedRoomID != "" {
+		routes = append(routes, &RoomRoute{
+			Name:        feed,
+			RoomID:      feedRoomID,
+			Verbosity:   cfg.MatrixVerbosity,
+			Screenshots: cfg.MatrixScreenshots,
+			Gallery:     cfg.MatrixGallery,
+			Templates:   cfg.Templates,
+		})
+		seen[feedRoomID] = true
+	}
+	for _, route := range cfg.Routes {
+		if seen[route.RoomID] || !route.Matches(feed, release, info) {
+			continue
+		}
+		routes = append(routes, route)
+		seen[route.RoomID] = true
+	}
+	return routes
+}
+
+// loadRoutes builds the routing rules from ROUTES and ROUTE_<NAME>_* settings
+func loadRoutes(cfg *Config, src *configSource) ([]*RoomRoute, error) {
+	var routes []*RoomRoute
+	seen := map[string]bool{}
+	for _, name := range splitList(src.get("ROUTES", "")) {
+		prefix := "ROUTE_" + feedEnvKey(name) + "_"
+		if seen[prefix] {
+			return nil, fmt.Errorf("route %q is listed more than once", name)
+		}
+		seen[prefix] = true
+
+		route := &RoomRoute{
+			Name:      name,
+			RoomID:    src.get(prefix+"ROOM", ""),
+			Feeds:     splitList(src.get(prefix+"FEEDS", "")),
+			Genres:    splitList(src.get(prefix+"GENRES", "")),
+			Platforms: splitList(src.get(prefix+"PLATFORMS", "")),
+			Groups:    splitList(src.get(prefix+"GROUPS", "")),
+			Verbosity: src.get(prefix+"VERBOSITY", cfg.MatrixVerbosity),
+		}
+		if route.RoomID == "" {
+			return nil, fmt.Errorf("%s is required", src.name(prefix+"ROOM"))
+		}
+		if value := src.get(prefix+"MIN_RATING", ""); value != "" {
+			rating, err := strconv.ParseFloat(value, 64)
+			if err != nil || rating < 0 || rating > 100 {
+				return nil, fmt.Errorf("%s must be a number between 0 and 100", src.name(prefix+"MIN_RATING"))
+			}
+			route.MinRating = rating
+		}
+		if value := src.get(prefix+"TITLE", ""); value != "" {
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", src.name(prefix+"TITLE"), err)
+			}
+			route.TitlePattern = pattern
+		}
+		screenshots, err := parseScreenshotCount(src.get(prefix+"SCREENSHOTS", strconv.Itoa(cfg.MatrixScreenshots)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", src.name(prefix+"SCREENSHOTS"), err)
+		}
+		route.Screenshots = screenshots
+		if route.Gallery, err = strconv.ParseBool(src.get(prefix+"GALLERY", strconv.FormatBool(cfg.MatrixGallery))); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", src.name(prefix+"GALLERY"), err)
+		}
+		route.Templates = cfg.Templates
+		if route.TemplateDir = src.get(prefix+"TEMPLATES", ""); route.TemplateDir != "" {
+			if route.Templates, err = loadMessageTemplates(cfg.MatrixTemplates, route.TemplateDir); err != nil {
+				return nil, fmt.Errorf("invalid %s: %v", src.name(prefix+"TEMPLATES"), err)
+			}
+		}
+		if !validVerbosity(route.Verbosity) {
+			return nil, fmt.Errorf("%s must be full, compact or minimal", src.name(prefix+"VERBOSITY"))
+		}
+
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// parseScreenshotCount parses a per-room screenshot count
+func parseScreenshotCount(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > maxRouteScreenshots {
+		return 0, fmt.Errorf("must be a number between 0 and %d", maxRouteScreenshots)
+	}
+	return n, nil
+}
+
+func validVerbosity(verbosity string) bool {
+	switch verbosity {
+	case VerbosityFull, VerbosityCompact, VerbosityMinimal:
+		return true
+	}
+	return false
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// anySubstringFold reports whether any value contains any of the patterns, ignoring case,
+// so that "RPG" matches IGDB's "Role-playing (RPG)"
+func anySubstringFold(values, patterns []string) bool {
+	for _, value := range values {
+		value = strings.ToLower(value)
+		for _, pattern := range patterns {
+			if strings.Contains(value, strings.ToLower(pattern)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestRoomRouteMatches(t *testing.T) {
+	release := &Release{Title: "Baldurs.Gate.3-RUNE", Group: "RUNE"}
+	info := &IGDBGameInfo{Genres: []string{"Role-playing (RPG)", "Strategy"}, Platforms: []string{"PC (Microsoft Windows)"}, TotalRating: 92}
+
+	cases := []struct {
+		name  string
+		route RoomRoute
+		info  *IGDBGameInfo
+		want  bool
+	}{
+		{"empty route", RoomRoute{}, nil, true},
+		{"feed", RoomRoute{Feeds: []string{"Zamunda"}}, info, true},
+		{"other feed", RoomRoute{Feeds: []string{"arena"}}, info, false},
+		{"group", RoomRoute{Groups: []string{"rune"}}, nil, true},
+		{"other group", RoomRoute{Groups: []string{"FLT"}}, nil, false},
+		{"title", RoomRoute{TitlePattern: regexp.MustCompile(`(?i)baldur`)}, nil, true},
+		{"genre substring", RoomRoute{Genres: []string{"rpg"}}, info, true},
+		{"genre without IGDB", RoomRoute{Genres: []string{"rpg"}}, nil, false},
+		{"platform", RoomRoute{Platforms: []string{"PlayStation"}}, info, false},
+		{"rating", RoomRoute{MinRating: 90}, info, true},
+		{"rating too low", RoomRoute{MinRating: 95}, info, false},
+	}
+	for _, tc := range cases {
+		if got := tc.route.Matches("zamunda", release, tc.info); got != tc.want {
+			t.Errorf("%s: Matches = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRoutesForUsesEachRoomOnce(t *testing.T) {
+	cfg := &Config{
+		MatrixVerbosity: VerbosityFull,
+		Routes: []*RoomRoute{
+			{Name: "feed room", RoomID: "!feed:example.org", Verbosity: VerbosityMinimal},
+			{Name: "rpg", RoomID: "!rpg:example.org", Genres: []string{"rpg"}, Verbosity: VerbosityCompact},
+			{Name: "rpg again", RoomID: "!rpg:example.org", Verbosity: VerbosityMinimal},
+			{Name: "strategy", RoomID: "!strategy:example.org", Genres: []string{"strategy"}},
+		},
+	}
+	rp := &RSSProcessor{config: cfg}
+	info := &IGDBGameInfo{Genres: []string{"Role-playing (RPG)"}}
+
+	routes := rp.routesFor("zamunda", "!feed:example.org", &Release{}, info)
+	var got []string
+	for _, route := range routes {
+		got = append(got, route.Name+"@"+route.RoomID)
+	}
+	want := []string{"zamunda@!feed:example.org", "rpg@!rpg:example.org"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("routesFor = %v, want %v", got, want)
+	}
+}
+
+func TestConfigRoomIDs(t *testing.T) {
+	cfg := &Config{
+		MatrixRoomID: "!default:example.org",
+		Feeds:        []*FeedConfig{{RoomID: "!default:example.org"}, {RoomID: "!feed:example.org"}},
+		Routes:       []*RoomRoute{{RoomID: "!route:example.org"}, {RoomID: "!feed:example.org"}},
+	}
+	want := []string{"!default:example.org", "!feed:example.org", "!route:example.org"}
+	if got := configRoomIDs(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("configRoomIDs = %v, want %v", got, want)
+	}
+}
@@ -2,33 +2,421 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// DB schema: processed_posts(post_id TEXT PRIMARY KEY)
+// migrations holds the schema history; migration N (1-based) upgrades the
+// database from user_version N-1 to N. Only ever append to this list.
+var migrations = []string{
+	// 1: baseline schema; databases created before versioning already have processed_posts
+	`CREATE TABLE IF NOT EXISTS processed_posts (post_id TEXT PRIMARY KEY);
+	CREATE TABLE IF NOT EXISTS igdb_query_cache (
+		query      TEXT PRIMARY KEY,
+		game_id    INTEGER NOT NULL,
+		fetched_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS igdb_game_cache (
+		game_id    INTEGER PRIMARY KEY,
+		info       TEXT NOT NULL,
+		fetched_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL
+	);`,
+
+	// 2: release history replaces processed_posts; old posts are kept as sent releases
+	`CREATE TABLE releases (
+		guid           TEXT PRIMARY KEY,
+		feed           TEXT NOT NULL DEFAULT '',
+		raw_title      TEXT NOT NULL DEFAULT '',
+		extracted_name TEXT NOT NULL DEFAULT '',
+		igdb_id        INTEGER,
+		match_score    REAL,
+		event_ids      TEXT NOT NULL DEFAULT '[]',
+		status         TEXT NOT NULL CHECK (status IN ('sent', 'failed', 'skipped')),
+		error          TEXT NOT NULL DEFAULT '',
+		created_at     INTEGER NOT NULL,
+		updated_at     INTEGER NOT NULL
+	);
+	CREATE INDEX releases_igdb_id ON releases (igdb_id);
+	CREATE INDEX releases_created_at ON releases (created_at);
+	INSERT INTO releases (guid, status, created_at, updated_at)
+		SELECT post_id, 'sent', strftime('%s', 'now'), strftime('%s', 'now') FROM processed_posts;
+	DROP TABLE processed_posts;`,
+
+	// 3: outbox columns for retrying failed deliveries; rows that failed before are dead-lettered
+	`CREATE TABLE releases_v3 (
+		guid            TEXT PRIMARY KEY,
+		feed            TEXT NOT NULL DEFAULT '',
+		raw_title       TEXT NOT NULL DEFAULT '',
+		extracted_name  TEXT NOT NULL DEFAULT '',
+		igdb_id         INTEGER,
+		match_score     REAL,
+		event_ids       TEXT NOT NULL DEFAULT '[]',
+		status          TEXT NOT NULL CHECK (status IN ('pending', 'sent', 'skipped', 'dead')),
+		error           TEXT NOT NULL DEFAULT '',
+		room_id         TEXT NOT NULL DEFAULT '',
+		payload         TEXT NOT NULL DEFAULT '',
+		attempts        INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at INTEGER NOT NULL DEFAULT 0,
+		created_at      INTEGER NOT NULL,
+		updated_at      INTEGER NOT NULL
+	);
+	INSERT INTO releases_v3 (guid, feed, raw_title, extracted_name, igdb_id, match_score, event_ids, status, error, attempts, created_at, updated_at)
+		SELECT guid, feed, raw_title, extracted_name, igdb_id, match_score, event_ids,
+			CASE status WHEN 'failed' THEN 'dead' ELSE status END, error,
+			CASE status WHEN 'failed' THEN 1 ELSE 0 END, created_at, updated_at
+		FROM releases;
+	DROP TABLE releases;
+	ALTER TABLE releases_v3 RENAME TO releases;
+	CREATE INDEX releases_igdb_id ON releases (igdb_id);
+	CREATE INDEX releases_created_at ON releases (created_at);
+	CREATE INDEX releases_due ON releases (status, next_attempt_at);`,
+
+	// 4: games muted from the Matrix room
+	`CREATE TABLE muted_games (
+		name       TEXT PRIMARY KEY,
+		display    TEXT NOT NULL,
+		muted_by   TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL
+	);`,
+
+	// 5: images uploaded to the homeserver, by content and by IGDB image ID
+	`CREATE TABLE media_cache (
+		sha256     TEXT NOT NULL,
+		variant    TEXT NOT NULL,
+		encrypted  INTEGER NOT NULL,
+		media      TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (sha256, variant, encrypted)
+	);
+	CREATE TABLE media_sources (
+		image_id TEXT PRIMARY KEY,
+		sha256   TEXT NOT NULL
+	);`,
+
+	// 6: access tokens obtained at runtime, previously written back to .env
+	`CREATE TABLE credentials (
+		name       TEXT PRIMARY KEY,
+		value      TEXT NOT NULL,
+		expires_at INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL
+	);`,
+
+	// 7: score breakdown of every IGDB candidate of the last uncached search of each query
+	`CREATE TABLE match_candidates (
+		query          TEXT NOT NULL,
+		rank           INTEGER NOT NULL,
+		game_id        INTEGER NOT NULL,
+		name           TEXT NOT NULL,
+		release_date   INTEGER NOT NULL DEFAULT 0,
+		category       INTEGER NOT NULL DEFAULT 0,
+		base           REAL NOT NULL,
+		recency        REAL NOT NULL,
+		category_bonus REAL NOT NULL,
+		age_penalty    REAL NOT NULL,
+		penalty_word   TEXT NOT NULL DEFAULT '',
+		word_penalty   REAL NOT NULL,
+		score          REAL NOT NULL,
+		created_at     INTEGER NOT NULL,
+		PRIMARY KEY (query, rank)
+	);`,
+
+	// 8: IGDB matches pinned by !rematch; unlike igdb_query_cache they never expire
+	`CREATE TABLE igdb_pins (
+		query      TEXT PRIMARY KEY,
+		game_id    INTEGER NOT NULL,
+		created_at INTEGER NOT NULL
+	);`,
+}
+
+// Release statuses stored in the releases table
+const (
+	ReleaseStatusPending = "pending" // queued for delivery or waiting to be retried
+	ReleaseStatusSent    = "sent"
+	ReleaseStatusSkipped = "skipped"
+	ReleaseStatusDead    = "dead" // gave up after too many failed attempts
+)
+
+// ReleaseRecord is a row of the releases table
+type ReleaseRecord struct {
+	GUID          string
+	Feed          string
+	RawTitle      string
+	ExtractedName string
+	IGDBID        int
+	MatchScore    float64
+	EventIDs      []string
+	Status        string
+	Error         string
+	RoomID        string
+	Payload       string
+	Attempts      int
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
 func initDB(path string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, err
 	}
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS processed_posts (post_id TEXT PRIMARY KEY)`)
-	if err != nil {
+	// Feeds are polled concurrently; serialize access to avoid "database is locked"
+	db.SetMaxOpenConns(1)
+	if err := migrateDB(db); err != nil {
+		db.Close()
 		return nil, err
 	}
+	// The database holds access tokens, so only our user may read it
+	if err := os.Chmod(path, 0600); err != nil {
+		log.Printf("Failed to restrict permissions of %s: %v", path, err)
+	}
 	return db, nil
 }
 
+// migrateDB applies every migration newer than the database's user_version
+func migrateDB(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record schema version %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		log.Printf("Applied database migration %d", i+1)
+	}
+	return nil
+}
+
 func isPostProcessed(db *sql.DB, postID string) (bool, error) {
 	var id string
-	err := db.QueryRow(`SELECT post_id FROM processed_posts WHERE post_id = ?`, postID).Scan(&id)
+	err := db.QueryRow(`SELECT guid FROM releases WHERE guid = ?`, postID).Scan(&id)
 	if err == sql.ErrNoRows {
 		return false, nil
 	}
 	return err == nil, err
 }
 
-func markPostProcessed(db *sql.DB, postID string) error {
-	_, err := db.Exec(`INSERT OR IGNORE INTO processed_posts (post_id) VALUES (?)`, postID)
+// saveRelease inserts or updates a release record, keeping its original creation time
+func saveRelease(db *sql.DB, rec *ReleaseRecord) error {
+	eventIDs, err := json.Marshal(rec.EventIDs)
+	if err != nil {
+		return err
+	}
+	if rec.EventIDs == nil {
+		eventIDs = []byte("[]")
+	}
+	now := time.Now()
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = now
+	}
+	rec.UpdatedAt = now
+
+	var nextAttemptAt int64
+	if !rec.NextAttemptAt.IsZero() {
+		nextAttemptAt = rec.NextAttemptAt.Unix()
+	}
+
+	_, err = db.Exec(`INSERT INTO releases
+		(guid, feed, raw_title, extracted_name, igdb_id, match_score, event_ids, status, error,
+		 room_id, payload, attempts, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (guid) DO UPDATE SET
+			feed = excluded.feed,
+			raw_title = excluded.raw_title,
+			extracted_name = excluded.extracted_name,
+			igdb_id = excluded.igdb_id,
+			match_score = excluded.match_score,
+			event_ids = excluded.event_ids,
+			status = excluded.status,
+			error = excluded.error,
+			room_id = excluded.room_id,
+			payload = excluded.payload,
+			attempts = excluded.attempts,
+			next_attempt_at = excluded.next_attempt_at,
+			updated_at = excluded.updated_at`,
+		rec.GUID, rec.Feed, rec.RawTitle, rec.ExtractedName, nullInt(rec.IGDBID), nullFloat(rec.MatchScore, rec.IGDBID != 0),
+		string(eventIDs), rec.Status, rec.Error, rec.RoomID, rec.Payload, rec.Attempts, nextAttemptAt,
+		rec.CreatedAt.Unix(), rec.UpdatedAt.Unix())
+	return err
+}
+
+// dueReleases returns a feed's pending releases whose next attempt is due
+func dueReleases(db *sql.DB, feed string, now time.Time) ([]*ReleaseRecord, error) {
+	rows, err := db.Query(releaseSelect+` WHERE feed = ? AND status = ? AND next_attempt_at <= ? ORDER BY created_at`,
+		feed, ReleaseStatusPending, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	return scanReleases(rows)
+}
+
+// recentReleases returns the most recently seen releases, newest first
+func recentReleases(db *sql.DB, limit int) ([]*ReleaseRecord, error) {
+	rows, err := db.Query(releaseSelect+` ORDER BY created_at DESC, rowid DESC LIMIT ?`, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanReleases(rows)
+}
+
+// releaseByEventID returns the release whose notification includes the given event, or nil
+func releaseByEventID(db *sql.DB, eventID string) (*ReleaseRecord, error) {
+	rows, err := db.Query(releaseSelect+` WHERE EXISTS (SELECT 1 FROM json_each(releases.event_ids) WHERE value = ?) LIMIT 1`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := scanReleases(rows)
+	if err != nil || len(recs) == 0 {
+		return nil, err
+	}
+	return recs[0], nil
+}
+
+// previousGameRelease returns the latest release of an IGDB game that was sent between since and
+// the given release, or nil
+func previousGameRelease(db *sql.DB, igdbID int, rec *ReleaseRecord, since time.Time) (*ReleaseRecord, error) {
+	rows, err := db.Query(releaseSelect+` WHERE igdb_id = ? AND status = ? AND guid != ? AND created_at BETWEEN ? AND ?
+		ORDER BY created_at DESC, rowid DESC LIMIT 1`,
+		igdbID, ReleaseStatusSent, rec.GUID, since.Unix(), rec.CreatedAt.Unix())
+	if err != nil {
+		return nil, err
+	}
+	recs, err := scanReleases(rows)
+	if err != nil || len(recs) == 0 {
+		return nil, err
+	}
+	return recs[0], nil
+}
+
+// releaseStatusCounts returns the number of releases in each status
+func releaseStatusCounts(db *sql.DB) (map[string]int, error) {
+	rows, err := db.Query(`SELECT status, COUNT(*) FROM releases GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, err
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
+
+// muteGame stops notifications for a game name until it is unmuted
+func muteGame(db *sql.DB, name, mutedBy string) error {
+	_, err := db.Exec(`INSERT OR REPLACE INTO muted_games (name, display, muted_by, created_at) VALUES (?, ?, ?, ?)`,
+		normalizeCacheKey(name), name, mutedBy, time.Now().Unix())
 	return err
 }
+
+// unmuteGame removes a muted game, reporting whether it was muted
+func unmuteGame(db *sql.DB, name string) (bool, error) {
+	res, err := db.Exec(`DELETE FROM muted_games WHERE name = ?`, normalizeCacheKey(name))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// isGameMuted reports whether any of the given names (extracted name, IGDB title) is muted
+func isGameMuted(db *sql.DB, names ...string) (bool, error) {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		var display string
+		err := db.QueryRow(`SELECT display FROM muted_games WHERE name = ?`, normalizeCacheKey(name)).Scan(&display)
+		if err == nil {
+			return true, nil
+		}
+		if err != sql.ErrNoRows {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// mutedGames lists the muted game names in the order they were muted
+func mutedGames(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT display FROM muted_games ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+const releaseSelect = `SELECT guid, feed, raw_title, extracted_name, COALESCE(igdb_id, 0), COALESCE(match_score, 0),
+	event_ids, status, error, room_id, payload, attempts, next_attempt_at, created_at, updated_at FROM releases`
+
+// scanReleases reads release records from a query built on releaseSelect
+func scanReleases(rows *sql.Rows) ([]*ReleaseRecord, error) {
+	defer rows.Close()
+	var recs []*ReleaseRecord
+	for rows.Next() {
+		rec := &ReleaseRecord{}
+		var eventIDs string
+		var nextAttemptAt, createdAt, updatedAt int64
+		if err := rows.Scan(&rec.GUID, &rec.Feed, &rec.RawTitle, &rec.ExtractedName, &rec.IGDBID, &rec.MatchScore,
+			&eventIDs, &rec.Status, &rec.Error, &rec.RoomID, &rec.Payload, &rec.Attempts, &nextAttemptAt,
+			&createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(eventIDs), &rec.EventIDs); err != nil {
+			return nil, fmt.Errorf("invalid event_ids for %s: %w", rec.GUID, err)
+		}
+		if nextAttemptAt != 0 {
+			rec.NextAttemptAt = time.Unix(nextAttemptAt, 0)
+		}
+		rec.CreatedAt = time.Unix(createdAt, 0)
+		rec.UpdatedAt = time.Unix(updatedAt, 0)
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+func nullInt(v int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
+}
+
+func nullFloat(v float64, valid bool) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: v, Valid: valid}
+}
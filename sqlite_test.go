@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestDB opens a fully migrated database in a temporary directory
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := initDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openRawDB opens an empty database without applying any migrations
+func openRawDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "raw.db"))
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// migrateTo applies the first n migrations only
+func migrateTo(t *testing.T, db *sql.DB, n int) {
+	t.Helper()
+	all := migrations
+	migrations = all[:n]
+	defer func() { migrations = all }()
+	if err := migrateDB(db); err != nil {
+		t.Fatalf("migrating to version %d: %v", n, err)
+	}
+}
+
+func schemaVersion(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatalf("reading user_version: %v", err)
+	}
+	return version
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := newTestDB(t)
+	if got := schemaVersion(t, db); got != len(migrations) {
+		t.Fatalf("user_version = %d, want %d", got, len(migrations))
+	}
+	for _, table := range []string{"releases", "igdb_query_cache", "igdb_game_cache", "muted_games", "media_cache", "media_sources", "credentials", "match_candidates", "igdb_pins"} {
+		var name string
+		if err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name); err != nil {
+			t.Errorf("table %s missing: %v", table, err)
+		}
+	}
+	// Running the migrations again is a no-op
+	if err := migrateDB(db); err != nil {
+		t.Fatalf("second migrateDB: %v", err)
+	}
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	db := openRawDB(t)
+	if _, err := db.Exec(`CREATE TABLE processed_posts (post_id TEXT PRIMARY KEY);
+		INSERT INTO processed_posts VALUES ('guid-1'), ('guid-2');`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDB(db); err != nil {
+		t.Fatalf("migrateDB: %v", err)
+	}
+
+	for _, guid := range []string{"guid-1", "guid-2"} {
+		processed, err := isPostProcessed(db, guid)
+		if err != nil || !processed {
+			t.Errorf("isPostProcessed(%s) = %v, %v", guid, processed, err)
+		}
+	}
+	var status string
+	if err := db.QueryRow(`SELECT status FROM releases WHERE guid = 'guid-1'`).Scan(&status); err != nil || status != ReleaseStatusSent {
+		t.Errorf("status = %q, %v; want sent", status, err)
+	}
+}
+
+func TestMigrateFailedReleasesAreDeadLettered(t *testing.T) {
+	db := openRawDB(t)
+	migrateTo(t, db, 2)
+	if _, err := db.Exec(`INSERT INTO releases (guid, feed, status, error, created_at, updated_at)
+		VALUES ('ok', 'zamunda', 'sent', '', 1, 1), ('broken', 'zamunda', 'failed', 'timeout', 2, 2)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDB(db); err != nil {
+		t.Fatalf("migrateDB: %v", err)
+	}
+
+	rows := map[string]struct {
+		status   string
+		attempts int
+	}{}
+	r, err := db.Query(`SELECT guid, status, attempts FROM releases`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		var guid, status string
+		var attempts int
+		if err := r.Scan(&guid, &status, &attempts); err != nil {
+			t.Fatal(err)
+		}
+		rows[guid] = struct {
+			status   string
+			attempts int
+		}{status, attempts}
+	}
+	if got := rows["ok"]; got.status != ReleaseStatusSent || got.attempts != 0 {
+		t.Errorf("ok = %+v", got)
+	}
+	if got := rows["broken"]; got.status != ReleaseStatusDead || got.attempts != 1 {
+		t.Errorf("broken = %+v", got)
+	}
+}
+
+func TestMigrateNewerSchemaIsRejected(t *testing.T) {
+	db := openRawDB(t)
+	if _, err := db.Exec(`PRAGMA user_version = 999`); err != nil {
+		t.Fatal(err)
+	}
+	err := migrateDB(db)
+	if err == nil || !strings.Contains(err.Error(), "newer than this binary supports") {
+		t.Fatalf("got %v, want a newer-schema error", err)
+	}
+}
+
+func TestMigrationFailureKeepsVersion(t *testing.T) {
+	db := openRawDB(t)
+	migrateTo(t, db, 1)
+	// A table that migration 2 is about to create makes it fail
+	if _, err := db.Exec(`CREATE TABLE releases (guid TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDB(db); err == nil || !strings.Contains(err.Error(), "migration 2 failed") {
+		t.Fatalf("got %v, want migration 2 to fail", err)
+	}
+	if got := schemaVersion(t, db); got != 1 {
+		t.Errorf("user_version = %d after a failed migration, want 1", got)
+	}
+}
+
+func TestSaveReleaseKeepsCreationTime(t *testing.T) {
+	db := newTestDB(t)
+	created := time.Unix(1700000000, 0)
+	rec := &ReleaseRecord{GUID: "g", Feed: "zamunda", RawTitle: "Starfield-RUNE", Status: ReleaseStatusPending, Error: "timeout", CreatedAt: created}
+	if err := saveRelease(db, rec); err != nil {
+		t.Fatal(err)
+	}
+	rec.Status = ReleaseStatusSent
+	rec.Error = ""
+	rec.EventIDs = []string{"$event"}
+	rec.IGDBID = 96437
+	rec.MatchScore = 0.9
+	if err := saveRelease(db, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	var status, eventIDs string
+	var igdbID int
+	var createdAt int64
+	err := db.QueryRow(`SELECT status, event_ids, igdb_id, created_at FROM releases WHERE guid = 'g'`).Scan(&status, &eventIDs, &igdbID, &createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != ReleaseStatusSent || eventIDs != `["$event"]` || igdbID != 96437 || createdAt != created.Unix() {
+		t.Errorf("got status %q, event_ids %s, igdb_id %d, created_at %d", status, eventIDs, igdbID, createdAt)
+	}
+	if processed, err := isPostProcessed(db, "g"); err != nil || !processed {
+		t.Errorf("isPostProcessed = %v, %v", processed, err)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Message kinds; each has a <kind>.txt.tmpl and a <kind>.html.tmpl template
+const (
+	templateGame    = "game"    // notification of a release found on IGDB, also used by !search
+	templateRelease = "release" // notification of a release without IGDB info
+	templateUpdate  = "update"  // newer release of a game posted in the earlier notification's thread
+)
+
+//go:embed templates/*.tmpl
+var defaultTemplateFS embed.FS
+
+// defaultTemplates renders messages for rooms without template overrides
+var defaultTemplates = mustLoadDefaultTemplates()
+
+// MessageData is what message templates are rendered with
+type MessageData struct {
+	Name      string        // game name: the IGDB title, or the name extracted from the release title
+	Game      *IGDBGameInfo // nil when the game was not found on IGDB
+	Release   *Release      // nil in !search replies
+	Verbosity string        // full, compact or minimal
+}
+
+// MessageTemplates renders the plain text and HTML bodies of messages. The HTML templates
+// escape IGDB and release text automatically.
+type MessageTemplates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templateFuncs are the helpers available to both text and HTML templates
+var templateFuncs = map[string]interface{}{
+	"truncate": truncateText,
+	"date":     templateDate,
+	"stars":    formatStars,
+	"join":     func(list []string, sep string) string { return strings.Join(list, sep) },
+	"rating":   formatRating,
+	"size":     formatSize,
+	"link":     func(s string) string { return s },
+}
+
+// loadMessageTemplates loads the default templates, then the *.txt.tmpl and *.html.tmpl files of each
+// directory in order, so that later directories override single templates of earlier ones
+func loadMessageTemplates(dirs ...string) (*MessageTemplates, error) {
+	htmlFuncs := htmltemplate.FuncMap{"link": templateLink}
+	for name, fn := range templateFuncs {
+		if _, ok := htmlFuncs[name]; !ok {
+			htmlFuncs[name] = fn
+		}
+	}
+	t := &MessageTemplates{
+		text: texttemplate.New("").Funcs(texttemplate.FuncMap(templateFuncs)),
+		html: htmltemplate.New("").Funcs(htmlFuncs),
+	}
+	if err := t.parse(defaultTemplateFS, "templates"); err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if info, err := os.Stat(dir); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		if err := t.parse(os.DirFS(dir), "."); err != nil {
+			return nil, err
+		}
+	}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// mustLoadDefaultTemplates loads the built-in templates, which always parse
+func mustLoadDefaultTemplates() *MessageTemplates {
+	t, err := loadMessageTemplates()
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// parse adds the templates of a directory, replacing templates with the same name
+func (t *MessageTemplates) parse(fsys fs.FS, dir string) error {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.tmpl"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		name := path.Base(file)
+		switch {
+		case strings.HasSuffix(name, ".txt.tmpl"):
+			_, err = t.text.New(name).Parse(string(data))
+		case strings.HasSuffix(name, ".html.tmpl"):
+			_, err = t.html.New(name).Parse(string(data))
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("invalid template %s: %v", file, err)
+		}
+	}
+	return nil
+}
+
+// validate renders every message kind with sample data, so that mistakes such as unknown
+// fields show up at startup rather than when a release arrives
+func (t *MessageTemplates) validate() error {
+	game := &IGDBGameInfo{
+		ID: 1, Title: "Sample Game", Date: time.Now().Unix(), Summary: "A sample game.",
+		Rating: 80, AggregatedRating: 85, TotalRating: 82,
+		Genres: []string{"Adventure"}, Platforms: []string{"PC (Microsoft Windows)"},
+	}
+	release := &Release{
+		Title: "Sample.Game-GROUP", Indexer: "sample", Size: 1 << 30, PubDate: time.Now(),
+		DetailsURL: "https://example.com/details", MagnetURL: "magnet:?xt=urn:btih:0",
+	}
+	samples := []struct {
+		kind string
+		data *MessageData
+	}{
+		{templateRelease, &MessageData{Name: game.Title, Release: release, Verbosity: VerbosityFull}},
+		{templateUpdate, &MessageData{Name: game.Title, Game: game, Release: release, Verbosity: VerbosityFull}},
+		{templateGame, &MessageData{Name: game.Title, Game: game, Verbosity: VerbosityFull}},
+	}
+	for _, verbosity := range []string{VerbosityFull, VerbosityCompact, VerbosityMinimal} {
+		samples = append(samples, struct {
+			kind string
+			data *MessageData
+		}{templateGame, &MessageData{Name: game.Title, Game: game, Release: release, Verbosity: verbosity}})
+	}
+	for _, sample := range samples {
+		if _, _, err := t.Render(sample.kind, sample.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render returns the plain text and HTML bodies of a message kind
+func (t *MessageTemplates) Render(kind string, data *MessageData) (string, string, error) {
+	var text, html strings.Builder
+	if err := t.text.ExecuteTemplate(&text, kind+".txt.tmpl", data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s.txt.tmpl: %v", kind, err)
+	}
+	if err := t.html.ExecuteTemplate(&html, kind+".html.tmpl", data); err != nil {
+		return "", "", fmt.Errorf("failed to render %s.html.tmpl: %v", kind, err)
+	}
+	return strings.TrimSpace(text.String()), strings.TrimSpace(html.String()), nil
+}
+
+// truncateText shortens s to at most n characters, ending with "..." when it was cut
+func truncateText(n int, s string) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string(runes[:n])
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+// templateDate formats a Unix timestamp or a time as 2006-01-02, or in the given layout
+func templateDate(value interface{}, layout ...string) (string, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case int64:
+		if v != 0 {
+			t = time.Unix(v, 0)
+		}
+	case int:
+		if v != 0 {
+			t = time.Unix(int64(v), 0)
+		}
+	default:
+		return "", fmt.Errorf("date: cannot format %T", value)
+	}
+	if t.IsZero() {
+		return "Unknown", nil
+	}
+	if len(layout) > 0 {
+		return t.Format(layout[0]), nil
+	}
+	return t.Format("2006-01-02"), nil
+}
+
+// formatStars shows a 0-100 rating as five stars, rounded to the nearest star
+func formatStars(rating float64) string {
+	if rating <= 0 {
+		return ""
+	}
+	n := int(math.Round(rating / 20))
+	if n > 5 {
+		n = 5
+	}
+	return strings.Repeat("★", n) + strings.Repeat("☆", 5-n)
+}
+
+// templateLink marks http(s) and magnet links as safe for href attributes; html/template
+// would otherwise replace magnet links. Other schemes are dropped.
+func templateLink(s string) htmltemplate.URL {
+	lower := strings.ToLower(s)
+	if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "magnet:") {
+		return htmltemplate.URL(s)
+	}
+	return "#"
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTemplateDir writes template overrides into a new directory
+func writeTemplateDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestMessageTemplatesOverride(t *testing.T) {
+	dir := writeTemplateDir(t, map[string]string{"release.txt.tmpl": "New: {{.Name}}", "notes.md": "ignored"})
+	tmpl, err := loadMessageTemplates(dir)
+	if err != nil {
+		t.Fatalf("loadMessageTemplates: %v", err)
+	}
+	text, html, err := tmpl.Render(templateRelease, &MessageData{Name: "Portal 2", Release: &Release{Title: "Portal.2-RUNE"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "New: Portal 2" {
+		t.Errorf("text = %q, want the override", text)
+	}
+	if !strings.Contains(html, "Portal.2-RUNE") {
+		t.Errorf("html = %q, want the default template", html)
+	}
+}
+
+func TestMessageTemplatesValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"unknown field", map[string]string{"game.txt.tmpl": "{{.Game.Nope}}"}, "game.txt.tmpl"},
+		{"unknown function", map[string]string{"update.html.tmpl": "{{shout .Name}}"}, "invalid template"},
+		{"syntax error", map[string]string{"release.txt.tmpl": "{{.Name"}, "invalid template"},
+		{"nil release", map[string]string{"game.txt.tmpl": "{{.Release.Title}}"}, "game.txt.tmpl"},
+	}
+	for _, tc := range cases {
+		_, err := loadMessageTemplates(writeTemplateDir(t, tc.files))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tc.name, err, tc.want)
+		}
+	}
+
+	if _, err := loadMessageTemplates(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+	file := filepath.Join(writeTemplateDir(t, map[string]string{"game.txt.tmpl": "{{.Name}}"}), "game.txt.tmpl")
+	if _, err := loadMessageTemplates(file); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("got %v, want an error for a file", err)
+	}
+}
+
+func TestMessageTemplatesEscapeHTML(t *testing.T) {
+	release := &Release{
+		Title:      "<b>Evil</b>-GROUP",
+		DetailsURL: "javascript:alert(1)",
+		MagnetURL:  "magnet:?xt=urn:btih:abc&dn=Evil",
+	}
+	_, html, err := defaultTemplates.Render(templateRelease, &MessageData{Name: "<script>x</script>", Release: release})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, unwanted := range []string{"<script>", "<b>Evil", "javascript:"} {
+		if strings.Contains(html, unwanted) {
+			t.Errorf("html contains %q: %s", unwanted, html)
+		}
+	}
+	if !strings.Contains(html, `href="magnet:?xt=urn:btih:abc&amp;dn=Evil"`) {
+		t.Errorf("magnet link missing: %s", html)
+	}
+}
+
+func TestTemplateHelpers(t *testing.T) {
+	if got := truncateText(8, "Half-Life 2"); got != "Half-..." {
+		t.Errorf("truncateText(8) = %q", got)
+	}
+	if got := truncateText(3, "Portal"); got != "Por" {
+		t.Errorf("truncateText(3) = %q", got)
+	}
+	if got := truncateText(20, "Ведьмак 3"); got != "Ведьмак 3" {
+		t.Errorf("truncateText(20) = %q", got)
+	}
+
+	for rating, want := range map[float64]string{0: "", 9: "☆☆☆☆☆", 50: "★★★☆☆", 81: "★★★★☆", 100: "★★★★★", 120: "★★★★★"} {
+		if got := formatStars(rating); got != want {
+			t.Errorf("formatStars(%v) = %q, want %q", rating, got, want)
+		}
+	}
+
+	day := time.Date(2023, 9, 6, 15, 4, 0, 0, time.Local)
+	for _, tc := range []struct {
+		value  interface{}
+		layout []string
+		want   string
+	}{
+		{day, nil, "2023-09-06"},
+		{day.Unix(), []string{"Jan 2006"}, "Sep 2023"},
+		{int64(0), nil, "Unknown"},
+		{time.Time{}, nil, "Unknown"},
+	} {
+		if got, err := templateDate(tc.value, tc.layout...); err != nil || got != tc.want {
+			t.Errorf("templateDate(%v) = %q, %v, want %q", tc.value, got, err, tc.want)
+		}
+	}
+	if _, err := templateDate("yesterday"); err == nil {
+		t.Error("expected an error for a string date")
+	}
+}
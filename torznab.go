@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TorznabClient talks to a single Torznab endpoint (e.g. one Jackett indexer)
+type TorznabClient struct {
+	endpoint   string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// TorznabCaps describes what a Torznab endpoint supports
+type TorznabCaps struct {
+	ServerTitle  string
+	DefaultLimit int
+	MaxLimit     int
+	SearchModes  map[string]TorznabSearchMode
+	Categories   []TorznabCategory
+}
+
+// TorznabSearchMode describes one search function (search, tv-search, ...)
+type TorznabSearchMode struct {
+	Available       bool
+	SupportedParams []string
+}
+
+// TorznabCategory is a category advertised in the caps document
+type TorznabCategory struct {
+	ID      int
+	Name    string
+	Subcats []TorznabCategory
+}
+
+// TorznabQuery holds the parameters for a t=search request
+type TorznabQuery struct {
+	Query      string
+	Categories []int
+	Limit      int
+	Offset     int
+}
+
+// TorznabItem is a single result from a Torznab feed with its torznab:attr values parsed
+type TorznabItem struct {
+	Title        string
+	GUID         string
+	Link         string
+	Comments     string
+	PubDate      time.Time
+	Size         int64
+	Categories   []int
+	Seeders      int
+	Peers        int
+	Grabs        int
+	InfoHash     string
+	MagnetURL    string
+	EnclosureURL string
+	Indexer      string
+	IndexerID    string
+	Attrs        map[string]string
+}
+
+// Leechers returns the number of peers that are not seeding
+func (ti *TorznabItem) Leechers() int {
+	if ti.Peers < ti.Seeders {
+		return 0
+	}
+	return ti.Peers - ti.Seeders
+}
+
+// TorznabError is returned when the endpoint answers with an <error> document
+type TorznabError struct {
+	Code        int
+	Description string
+}
+
+func (e *TorznabError) Error() string {
+	return fmt.Sprintf("torznab error %d: %s", e.Code, e.Description)
+}
+
+// NewTorznabClient creates a client for the given Torznab API endpoint
+func NewTorznabClient(endpoint, apiKey string, httpClient *http.Client) *TorznabClient {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &TorznabClient{
+		endpoint:   endpoint,
+		apiKey:     apiKey,
+		httpClient: httpClient,
+	}
+}
+
+// JackettIndexerEndpoint builds the Torznab endpoint for a Jackett indexer ("all" queries every configured indexer)
+func JackettIndexerEndpoint(jackettURL, indexer string) string {
+	return strings.TrimRight(jackettURL, "/") + "/api/v2.0/indexers/" + url.PathEscape(indexer) + "/results/torznab/api"
+}
+
+// Caps fetches and parses the capabilities document (t=caps)
+func (tc *TorznabClient) Caps(ctx context.Context) (*TorznabCaps, error) {
+	body, err := tc.get(ctx, url.Values{"t": {"caps"}})
+	if err != nil {
+		return nil, err
+	}
+	return parseTorznabCaps(body)
+}
+
+// Search runs a t=search query and returns the parsed items
+func (tc *TorznabClient) Search(ctx context.Context, q TorznabQuery) ([]*TorznabItem, error) {
+	params := url.Values{"t": {"search"}}
+	params.Set("q", q.Query)
+	if len(q.Categories) > 0 {
+		cats := make([]string, 0, len(q.Categories))
+		for _, c := range q.Categories {
+			cats = append(cats, strconv.Itoa(c))
+		}
+		params.Set("cat", strings.Join(cats, ","))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset > 0 {
+		params.Set("offset", strconv.Itoa(q.Offset))
+	}
+
+	body, err := tc.get(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return parseTorznabFeed(body)
+}
+
+// get performs a GET against the endpoint with the API key attached
+func (tc *TorznabClient) get(ctx context.Context, params url.Values) ([]byte, error) {
+	u, err := url.Parse(tc.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid torznab endpoint %q: %w", tc.endpoint, err)
+	}
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if tc.apiKey != "" {
+		query.Set("apikey", tc.apiKey)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := tc.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("torznab request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read torznab response: %w", err)
+	}
+
+	// Torznab reports API errors as an <error> document, sometimes with a 200 status
+	if tzErr := parseTorznabError(body); tzErr != nil {
+		return nil, tzErr
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("torznab request returned %s", resp.Status)
+	}
+	return body, nil
+}
+
+// parseTorznabError returns a *TorznabError if body is an <error> document
+func parseTorznabError(body []byte) *TorznabError {
+	var doc struct {
+		XMLName     xml.Name `xml:"error"`
+		Code        int      `xml:"code,attr"`
+		Description string   `xml:"description,attr"`
+	}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil
+	}
+	return &TorznabError{Code: doc.Code, Description: doc.Description}
+}
+
+type torznabCapsXML struct {
+	Server struct {
+		Title string `xml:"title,attr"`
+	} `xml:"server"`
+	Limits struct {
+		Default int `xml:"default,attr"`
+		Max     int `xml:"max,attr"`
+	} `xml:"limits"`
+	Searching struct {
+		Modes []struct {
+			XMLName         xml.Name
+			Available       string `xml:"available,attr"`
+			SupportedParams string `xml:"supportedParams,attr"`
+		} `xml:",any"`
+	} `xml:"searching"`
+	Categories []torznabCategoryXML `xml:"categories>category"`
+}
+
+type torznabCategoryXML struct {
+	ID      int                  `xml:"id,attr"`
+	Name    string               `xml:"name,attr"`
+	Subcats []torznabCategoryXML `xml:"subcat"`
+}
+
+// parseTorznabCaps parses a t=caps response
+func parseTorznabCaps(body []byte) (*TorznabCaps, error) {
+	var doc torznabCapsXML
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse torznab caps: %w", err)
+	}
+
+	caps := &TorznabCaps{
+		ServerTitle:  doc.Server.Title,
+		DefaultLimit: doc.Limits.Default,
+		MaxLimit:     doc.Limits.Max,
+		SearchModes:  map[string]TorznabSearchMode{},
+	}
+	for _, mode := range doc.Searching.Modes {
+		var params []string
+		for _, p := range strings.Split(mode.SupportedParams, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				params = append(params, p)
+			}
+		}
+		caps.SearchModes[mode.XMLName.Local] = TorznabSearchMode{
+			Available:       mode.Available == "yes",
+			SupportedParams: params,
+		}
+	}
+
+	var convert func(c torznabCategoryXML) TorznabCategory
+	convert = func(c torznabCategoryXML) TorznabCategory {
+		cat := TorznabCategory{ID: c.ID, Name: c.Name}
+		for _, sub := range c.Subcats {
+			cat.Subcats = append(cat.Subcats, convert(sub))
+		}
+		return cat
+	}
+	for _, c := range doc.Categories {
+		caps.Categories = append(caps.Categories, convert(c))
+	}
+
+	return caps, nil
+}
+
+// HasCategory reports whether a category or subcategory with the given ID is advertised
+func (c *TorznabCaps) HasCategory(id int) bool {
+	var find func(cats []TorznabCategory) bool
+	find = func(cats []TorznabCategory) bool {
+		for _, cat := range cats {
+			if cat.ID == id || find(cat.Subcats) {
+				return true
+			}
+		}
+		return false
+	}
+	return find(c.Categories)
+}
+
+// SupportsSearch reports whether the basic t=search function is available
+func (c *TorznabCaps) SupportsSearch() bool {
+	mode, ok := c.SearchModes["search"]
+	return ok && mode.Available
+}
+
+type torznabFeedXML struct {
+	Channel struct {
+		Items []torznabItemXML `xml:"item"`
+	} `xml:"channel"`
+}
+
+type torznabItemXML struct {
+	Title      string   `xml:"title"`
+	GUID       string   `xml:"guid"`
+	Link       string   `xml:"link"`
+	Comments   string   `xml:"comments"`
+	PubDate    string   `xml:"pubDate"`
+	Size       int64    `xml:"size"`
+	Categories []string `xml:"category"`
+	Indexer    struct {
+		ID   string `xml:"id,attr"`
+		Name string `xml:",chardata"`
+	} `xml:"jackettindexer"`
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length int64  `xml:"length,attr"`
+		Type   string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	Attrs []struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value,attr"`
+	} `xml:"http://torznab.com/schemas/2015/feed attr"`
+}
+
+// parseTorznabFeed parses a t=search response into items
+func parseTorznabFeed(body []byte) ([]*TorznabItem, error) {
+	var doc torznabFeedXML
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse torznab feed: %w", err)
+	}
+
+	items := make([]*TorznabItem, 0, len(doc.Channel.Items))
+	for _, raw := range doc.Channel.Items {
+		item := &TorznabItem{
+			Title:        strings.TrimSpace(raw.Title),
+			GUID:         strings.TrimSpace(raw.GUID),
+			Link:         strings.TrimSpace(raw.Link),
+			Comments:     strings.TrimSpace(raw.Comments),
+			Size:         raw.Size,
+			EnclosureURL: raw.Enclosure.URL,
+			Indexer:      strings.TrimSpace(raw.Indexer.Name),
+			IndexerID:    raw.Indexer.ID,
+			Attrs:        map[string]string{},
+		}
+		if item.Size == 0 {
+			item.Size = raw.Enclosure.Length
+		}
+		if pub, err := parseTorznabDate(raw.PubDate); err == nil {
+			item.PubDate = pub
+		}
+		for _, c := range raw.Categories {
+			if id, err := strconv.Atoi(strings.TrimSpace(c)); err == nil {
+				item.Categories = append(item.Categories, id)
+			}
+		}
+
+		for _, attr := range raw.Attrs {
+			item.Attrs[attr.Name] = attr.Value
+			switch attr.Name {
+			case "seeders":
+				item.Seeders, _ = strconv.Atoi(attr.Value)
+			case "peers":
+				item.Peers, _ = strconv.Atoi(attr.Value)
+			case "grabs":
+				item.Grabs, _ = strconv.Atoi(attr.Value)
+			case "size":
+				if size, err := strconv.ParseInt(attr.Value, 10, 64); err == nil {
+					item.Size = size
+				}
+			case "infohash":
+				item.InfoHash = strings.ToLower(attr.Value)
+			case "magneturl":
+				item.MagnetURL = attr.Value
+			case "category":
+				if id, err := strconv.Atoi(attr.Value); err == nil && !containsInt(item.Categories, id) {
+					item.Categories = append(item.Categories, id)
+				}
+			}
+		}
+
+		// Some indexers only expose the magnet link as the enclosure or link
+		if item.MagnetURL == "" && strings.HasPrefix(item.Link, "magnet:") {
+			item.MagnetURL = item.Link
+		}
+		if item.GUID == "" {
+			item.GUID = item.Link
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// parseTorznabDate parses the RFC 1123-ish dates used in RSS pubDate
+func parseTorznabDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	layouts := []string{time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", time.RFC3339}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q", value)
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
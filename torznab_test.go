@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testTorznabCaps = `<?xml version="1.0" encoding="UTF-8"?>
+<caps>
+  <server title="Jackett" />
+  <limits default="100" max="500" />
+  <searching>
+    <search available="yes" supportedParams="q" />
+    <tv-search available="no" supportedParams="q,season,ep" />
+    <movie-search available="yes" supportedParams="q, imdbid" />
+  </searching>
+  <categories>
+    <category id="4000" name="PC">
+      <subcat id="4050" name="PC/Games" />
+    </category>
+    <category id="1000" name="Console" />
+  </categories>
+</caps>`
+
+const testTorznabFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:torznab="http://torznab.com/schemas/2015/feed">
+  <channel>
+    <title>zamunda</title>
+    <item>
+      <title> Starfield-RUNE </title>
+      <guid>https://zamunda.net/details.php?id=1</guid>
+      <jackettindexer id="zamunda">Zamunda</jackettindexer>
+      <link>https://jackett.local/dl/zamunda/?path=1</link>
+      <comments>https://zamunda.net/details.php?id=1</comments>
+      <pubDate>Wed, 06 Sep 2023 10:15:00 +0300</pubDate>
+      <size>125000000000</size>
+      <category>4000</category>
+      <enclosure url="https://jackett.local/dl/zamunda/?path=1" length="125000000000" type="application/x-bittorrent" />
+      <torznab:attr name="category" value="4050" />
+      <torznab:attr name="seeders" value="42" />
+      <torznab:attr name="peers" value="50" />
+      <torznab:attr name="grabs" value="1000" />
+      <torznab:attr name="infohash" value="ABCDEF0123" />
+      <torznab:attr name="downloadvolumefactor" value="0" />
+    </item>
+    <item>
+      <title>Hades II-TENOKE</title>
+      <link>magnet:?xt=urn:btih:0123456789</link>
+      <pubDate>Thu, 7 Sep 2023 08:00:00 +0000</pubDate>
+      <enclosure url="magnet:?xt=urn:btih:0123456789" length="9000" type="application/x-bittorrent" />
+      <torznab:attr name="seeders" value="3" />
+      <torznab:attr name="peers" value="1" />
+    </item>
+  </channel>
+</rss>`
+
+const testTorznabError = `<?xml version="1.0" encoding="UTF-8"?>
+<error code="100" description="Invalid API Key" />`
+
+// newTestTorznabServer serves body for every request and records the last query
+func newTestTorznabServer(t *testing.T, status int, body string, lastQuery *map[string]string) *TorznabClient {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lastQuery != nil {
+			q := map[string]string{}
+			for k := range r.URL.Query() {
+				q[k] = r.URL.Query().Get(k)
+			}
+			*lastQuery = q
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return NewTorznabClient(srv.URL+"/api", "secret", srv.Client())
+}
+
+func TestTorznabCaps(t *testing.T) {
+	var query map[string]string
+	client := newTestTorznabServer(t, http.StatusOK, testTorznabCaps, &query)
+
+	caps, err := client.Caps(context.Background())
+	if err != nil {
+		t.Fatalf("Caps: %v", err)
+	}
+	if query["t"] != "caps" || query["apikey"] != "secret" {
+		t.Errorf("unexpected query %v", query)
+	}
+	if caps.ServerTitle != "Jackett" || caps.DefaultLimit != 100 || caps.MaxLimit != 500 {
+		t.Errorf("unexpected server/limits: %+v", caps)
+	}
+	if !caps.SupportsSearch() {
+		t.Error("expected search to be available")
+	}
+	if caps.SearchModes["tv-search"].Available {
+		t.Error("expected tv-search to be unavailable")
+	}
+	if got := caps.SearchModes["movie-search"].SupportedParams; len(got) != 2 || got[1] != "imdbid" {
+		t.Errorf("movie-search params = %v", got)
+	}
+	if !caps.HasCategory(4050) || !caps.HasCategory(1000) {
+		t.Error("expected subcategory 4050 and category 1000 to be advertised")
+	}
+	if caps.HasCategory(3000) {
+		t.Error("expected unknown category to be missing")
+	}
+	if len(caps.Categories) != 2 || len(caps.Categories[0].Subcats) != 1 {
+		t.Errorf("unexpected category tree: %+v", caps.Categories)
+	}
+}
+
+func TestTorznabSearch(t *testing.T) {
+	var query map[string]string
+	client := newTestTorznabServer(t, http.StatusOK, testTorznabFeed, &query)
+
+	items, err := client.Search(context.Background(), TorznabQuery{Query: "starfield", Categories: []int{4000, 4050}, Limit: 50, Offset: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	want := map[string]string{"t": "search", "q": "starfield", "cat": "4000,4050", "limit": "50", "offset": "10", "apikey": "secret"}
+	for k, v := range want {
+		if query[k] != v {
+			t.Errorf("query[%s] = %q, want %q", k, query[k], v)
+		}
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+
+	first := items[0]
+	if first.Title != "Starfield-RUNE" {
+		t.Errorf("Title = %q", first.Title)
+	}
+	if first.Indexer != "Zamunda" || first.IndexerID != "zamunda" {
+		t.Errorf("indexer = %q/%q", first.Indexer, first.IndexerID)
+	}
+	if first.Seeders != 42 || first.Peers != 50 || first.Leechers() != 8 || first.Grabs != 1000 {
+		t.Errorf("peers = %d/%d/%d grabs %d", first.Seeders, first.Peers, first.Leechers(), first.Grabs)
+	}
+	if first.InfoHash != "abcdef0123" {
+		t.Errorf("InfoHash = %q", first.InfoHash)
+	}
+	if first.Size != 125000000000 {
+		t.Errorf("Size = %d", first.Size)
+	}
+	if len(first.Categories) != 2 || first.Categories[0] != 4000 || first.Categories[1] != 4050 {
+		t.Errorf("Categories = %v", first.Categories)
+	}
+	if first.Attrs["downloadvolumefactor"] != "0" {
+		t.Errorf("Attrs = %v", first.Attrs)
+	}
+	if want := time.Date(2023, 9, 6, 7, 15, 0, 0, time.UTC); !first.PubDate.Equal(want) {
+		t.Errorf("PubDate = %v, want %v", first.PubDate, want)
+	}
+
+	second := items[1]
+	if second.MagnetURL != "magnet:?xt=urn:btih:0123456789" {
+		t.Errorf("MagnetURL = %q", second.MagnetURL)
+	}
+	if second.GUID != second.Link {
+		t.Errorf("GUID = %q, want link fallback", second.GUID)
+	}
+	if second.Size != 9000 {
+		t.Errorf("Size = %d, want enclosure length", second.Size)
+	}
+	if second.Leechers() != 0 {
+		t.Errorf("Leechers = %d, want 0", second.Leechers())
+	}
+}
+
+func TestTorznabErrorDocument(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusUnauthorized} {
+		client := newTestTorznabServer(t, status, testTorznabError, nil)
+		_, err := client.Search(context.Background(), TorznabQuery{Query: "x"})
+		var tzErr *TorznabError
+		if !errors.As(err, &tzErr) {
+			t.Fatalf("status %d: expected *TorznabError, got %v", status, err)
+		}
+		if tzErr.Code != 100 || tzErr.Description != "Invalid API Key" {
+			t.Errorf("status %d: got %+v", status, tzErr)
+		}
+	}
+}
+
+func TestTorznabHTTPStatus(t *testing.T) {
+	client := newTestTorznabServer(t, http.StatusBadGateway, "bad gateway", nil)
+	_, err := client.Caps(context.Background())
+	if err == nil {
+		t.Fatal("expected an error for a 502 response")
+	}
+	var tzErr *TorznabError
+	if errors.As(err, &tzErr) {
+		t.Errorf("plain HTTP failure reported as torznab error: %v", err)
+	}
+}
+
+func TestJackettIndexerEndpoint(t *testing.T) {
+	got := JackettIndexerEndpoint("http://jackett:9117/", "all")
+	if want := "http://jackett:9117/api/v2.0/indexers/all/results/torznab/api"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}